@@ -18,29 +18,64 @@ along with this program. If not, see <http://www.gnu.org/licenses/>.
 package clone
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	. "github.com/Logunov/heydevops/helpers"
+	"github.com/Logunov/heydevops/provider"
 	"github.com/sirupsen/logrus"
-	"github.com/xanzy/go-gitlab"
 	"os"
 	"os/exec"
 	re "regexp"
 	"strings"
 	"sync"
+	"time"
+)
+
+const (
+	LayoutSubmodules = "submodules"
+	LayoutClones     = "clones"
+	LayoutMirror     = "mirror"
 )
 
 type ConfigStruct struct {
 	Logger                    *logrus.Logger
 	DryRun                    bool
+	Incremental               bool
+	Prune                     bool
+	GitBackend                string
+	Layout                    string
+	MirrorWikis               bool
+	CloneProtocol             string
+	UpdateStrategy            string
+	OnDirty                   string
+	StateFile                 string
 	ExpandBranches            bool
+	Provider                  string
 	GitLabURL                 string
 	GitLabAPIURL              string
-	Token                     string
+	Token                     Secret
 	DetectMultiBranchFileName string
 	RootRemove                string
+	PathTemplate              string
+	SlugEncoding              string
 	CloneThreadsCount         int
 	ListOptionsPerPage        int
-	Repos                     SkipCloneStringsStruct
+	Groups                    []string
+	Filters                   provider.Filters
+	Retry                     RetryConfig
+	Repos                     ReposStruct
 	Branches                  BranchesStruct
+	Tags                      TagsStruct
+	MergeRequests             MergeRequestsStruct
+	CloneOptions              []*CloneOptionsStruct
+	Sources                   []*SourceStruct
+	// ConfirmPrune is asked before repos of projects deleted upstream are removed, they are kept when it is nil
+	ConfirmPrune func(paths []string) bool
+	// SelectedSources are names of sources to sync, all of them when empty
+	SelectedSources []string
+	// Offline is set by commands working on local repos only, hosting settings aren't required for them
+	Offline bool
 }
 
 type SkipCloneStringsStruct struct {
@@ -53,19 +88,31 @@ type SkipCloneRegexStruct struct {
 	Clone []*re.Regexp
 }
 
+// BranchesStruct sets expanded branches paths up and selects branches by name regexps and rules,
+// zero value rules aren't applied
 type BranchesStruct struct {
 	Prefix string
 	Suffix string
 	Slash  string
 	SkipCloneStringsStruct
+	ProtectedOnly bool
+	// MaxAgeDays skips branches with the last commit older than that
+	MaxAgeDays       int
+	OpenMergeRequest bool
+	// Latest keeps only that number of the most recently updated branches besides the default one
+	Latest int
 }
 
 var (
 	config                     *ConfigStruct
 	log                        *logrus.Logger
-	gitLabClient               *gitlab.Client
+	hosting                    provider.Provider
+	gitBackend                 Git
+	report                     *Report
+	state                      *State
 	reposSkipCloneRegexList    SkipCloneRegexStruct
 	branchesSkipCloneRegexList SkipCloneRegexStruct
+	tagsSkipCloneRegexList     SkipCloneRegexStruct
 	gitMutex                   sync.Mutex
 	waitGroup                  sync.WaitGroup
 )
@@ -74,37 +121,107 @@ func Init(configPtr *ConfigStruct) {
 	config = configPtr
 	log = config.Logger
 
+	setDefaultURL(config)
 	addSlashIfEndWithOutSlash(&config.GitLabURL)
 	addSlashIfEndWithOutSlash(&config.GitLabAPIURL)
+	if config.MergeRequests.Prefix == "" {
+		config.MergeRequests.Prefix = defaultMergeRequestsPrefix
+	}
+	if config.SlugEncoding == "" {
+		config.SlugEncoding = SlugLenient
+	}
 
 	log.Trace("Config Dry Run: ", config.DryRun)
+	log.Trace("Config Provider: ", config.Provider)
+	log.Trace("Config Incremental: ", config.Incremental)
+	log.Trace("Config Prune: ", config.Prune)
+	log.Trace("Config GitBackend: ", config.GitBackend)
+	log.Trace("Config Layout: ", config.Layout)
+	log.Trace("Config MirrorWikis: ", config.MirrorWikis)
+	log.Trace("Config CloneProtocol: ", config.CloneProtocol)
+	log.Trace("Config UpdateStrategy: ", config.UpdateStrategy)
+	log.Trace("Config OnDirty: ", config.OnDirty)
+	log.Trace("Config StateFile: ", config.StateFile)
 	log.Trace("Config GitLabURL: ", config.GitLabURL)
 	log.Trace("Config GitLabAPIURL: ", config.GitLabAPIURL)
 	log.Trace("Config Token: ", config.Token)
 	log.Trace("Config ListOptionsPerPage: ", config.ListOptionsPerPage)
+	log.Trace("Config Retry: ", config.Retry.Attempts, " attempts, delay ", config.Retry.Delay, " up to ", config.Retry.MaxDelay)
+	log.Trace("Config Groups: \n", strings.Join(config.Groups, "\n"))
 	log.Trace("Config Repos Clone: \n", strings.Join(config.Repos.Clone, "\n"))
 	log.Trace("Config Repos Skip: \n", strings.Join(config.Repos.Skip, "\n"))
+	log.Trace("Config Repos Topics: ", strings.Join(config.Repos.Topics, ", "))
+	log.Trace("Config Repos Visibility: ", strings.Join(config.Repos.Visibility, ", "))
+	log.Trace("Config Repos ActiveWithinDays: ", config.Repos.ActiveWithinDays)
+	log.Trace("Config Repos NamespaceKind: ", config.Repos.NamespaceKind)
+	log.Trace("Config PathTemplate: ", config.PathTemplate)
+	log.Trace("Config SlugEncoding: ", config.SlugEncoding)
 	log.Trace("Config Branches Prefix: ", config.Branches.Prefix)
 	log.Trace("Config Branches Suffix: ", config.Branches.Suffix)
 	log.Trace("Config Branches Slash: ", config.Branches.Slash)
 	log.Trace("Config Branches Clone: \n", strings.Join(config.Branches.Clone, "\n"))
 	log.Trace("Config Branches Skip: \n", strings.Join(config.Branches.Skip, "\n"))
+	log.Trace("Config Branches ProtectedOnly: ", config.Branches.ProtectedOnly)
+	log.Trace("Config Branches MaxAgeDays: ", config.Branches.MaxAgeDays)
+	log.Trace("Config Branches OpenMergeRequest: ", config.Branches.OpenMergeRequest)
+	log.Trace("Config Branches Latest: ", config.Branches.Latest)
+	log.Trace("Config Tags Prefix: ", config.Tags.Prefix)
+	log.Trace("Config Tags Suffix: ", config.Tags.Suffix)
+	log.Trace("Config Tags Slash: ", config.Tags.Slash)
+	log.Trace("Config Tags Clone: \n", strings.Join(config.Tags.Clone, "\n"))
+	log.Trace("Config Tags Skip: \n", strings.Join(config.Tags.Skip, "\n"))
+	log.Trace("Config Tags LatestSemver: ", config.Tags.LatestSemver)
+	log.Trace("Config MergeRequests Enabled: ", config.MergeRequests.Enabled)
+	log.Trace("Config MergeRequests Prefix: ", config.MergeRequests.Prefix)
+	log.Trace("Config MergeRequests Labels: ", strings.Join(config.MergeRequests.Labels, ", "))
+	log.Trace("Config MergeRequests Authors: ", strings.Join(config.MergeRequests.Authors, ", "))
+	log.Trace("Config MergeRequests TargetBranches: \n", strings.Join(config.MergeRequests.TargetBranches, "\n"))
+	for _, rule := range config.CloneOptions {
+		log.Trace("Config CloneOptions: ", strings.Join(rule.Repos, ", "), " filter ", rule.Filter, " depth ", rule.Depth, " sparse ", strings.Join(rule.Sparse, ", "))
+	}
+	for _, source := range config.Sources {
+		log.Trace("Config Source: ", source.Name, " ", source.GitLabURL, " into ", source.Subdir)
+	}
+	log.Trace("Config SelectedSources: ", strings.Join(config.SelectedSources, ", "))
 
+	compileRegexps()
+
+	var err error
+	if pathTemplate, err = parsePathTemplate(config.PathTemplate); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Trace("Core init done")
+}
+
+// compileRegexps compiles regexps of the config, they are compiled again for every source
+func compileRegexps() {
 	reposSkipCloneRegexList.Clone = compileSkipCloneRegexps(config.Repos.Clone)
 	reposSkipCloneRegexList.Skip = compileSkipCloneRegexps(config.Repos.Skip)
 	branchesSkipCloneRegexList.Clone = compileSkipCloneRegexps(config.Branches.Clone)
 	branchesSkipCloneRegexList.Skip = compileSkipCloneRegexps(config.Branches.Skip)
+	tagsSkipCloneRegexList.Clone = compileSkipCloneRegexps(config.Tags.Clone)
+	tagsSkipCloneRegexList.Skip = compileSkipCloneRegexps(config.Tags.Skip)
+	mergeRequestsTargetRegexList = compileSkipCloneRegexps(config.MergeRequests.TargetBranches)
+	compileCloneOptionsRegexps()
 
 	logTraceSkipCloneRegexps("Regexp Repos Cloneinfo", reposSkipCloneRegexList.Clone)
 	logTraceSkipCloneRegexps("Regexp Repos Skipinfo", reposSkipCloneRegexList.Skip)
 	logTraceSkipCloneRegexps("Regexp Branches Cloneinfo", branchesSkipCloneRegexList.Clone)
 	logTraceSkipCloneRegexps("Regexp Branches Skipinfo", branchesSkipCloneRegexList.Skip)
+	logTraceSkipCloneRegexps("Regexp Tags Cloneinfo", tagsSkipCloneRegexList.Clone)
+	logTraceSkipCloneRegexps("Regexp Tags Skipinfo", tagsSkipCloneRegexList.Skip)
+}
 
-	log.Trace("Core init done")
+// setDefaultURL sets GitHub address up when it isn't configured, GitLab and Gitea are self-hosted
+func setDefaultURL(configPtr *ConfigStruct) {
+	if configPtr.GitLabURL == "" && strings.EqualFold(configPtr.Provider, provider.GitHub) {
+		configPtr.GitLabURL = provider.GitHubURL
+	}
 }
 
 func addSlashIfEndWithOutSlash(strPtr *string) {
-	if (*strPtr)[len(*strPtr)-1:] != "/" {
+	if *strPtr != "" && !strings.HasSuffix(*strPtr, "/") {
 		*strPtr += "/"
 	}
 }
@@ -130,109 +247,244 @@ func logTraceSkipCloneRegexps(msg string, regexps []*re.Regexp) {
 	}
 }
 
-func Clone() {
+// Clone clones or updates every matched repo of every selected source and returns report of the run
+func Clone() *Report {
 	defer Elapsed("Clone")()
 
-	if config.Token == "" {
-		log.Fatal("GitLab Token is empty!")
-	}
-
 	if config.DryRun {
 		log.Info("Running in dry run mode, no really changes will be made")
 	}
 
-	gitLabClient = gitlab.NewClient(nil, config.Token)
+	report = newReport()
+	if len(config.Sources) > 0 {
+		cloneSources()
+	} else {
+		cloneSource()
+	}
+	return report
+}
+
+// cloneSource clones or updates every matched repo of the configured hosting into the current directory
+func cloneSource() {
+	// Path is the source subdir, other sources are still synced and the summary is printed
+	token, err := resolveToken(config.Token, config.GitLabURL)
+	if err != nil {
+		report.fail("", "", ".", err)
+		return
+	}
+	if token == "" {
+		report.fail("", "", ".", errors.New("GitLab Token is empty"))
+		return
+	}
 
 	if config.GitLabAPIURL == "" {
 		config.GitLabAPIURL = config.GitLabURL
 	}
-	CheckPanic(gitLabClient.SetBaseURL(config.GitLabAPIURL))
 
-	listProjectsOptions := &gitlab.ListProjectsOptions{
-		ListOptions: gitlab.ListOptions{
-			PerPage: config.ListOptionsPerPage,
-			Page:    1,
-		},
+	resetUpstream()
+	resetRepoPaths()
+	runStart := time.Now()
+	failedBefore := report.Count(StatusFailed)
+
+	switch config.Layout {
+	case "":
+		config.Layout = LayoutSubmodules
+	case LayoutSubmodules, LayoutClones:
+	case LayoutMirror:
+		if config.ExpandBranches {
+			log.Warn("Branches aren't expanded in mirror layout, mirrors have all of them")
+			config.ExpandBranches = false
+		}
+	default:
+		report.fail("", "", "", fmt.Errorf("unknown layout %q, supported: %s, %s, %s", config.Layout, LayoutSubmodules, LayoutClones, LayoutMirror))
+		return
 	}
 
-	projectsChan := make(chan *gitlab.Project, config.CloneThreadsCount)
-	for i := 0; i < config.CloneThreadsCount; i++ {
-		waitGroup.Add(1)
-		go addProject(projectsChan, &waitGroup)
+	if err := checkUpdateConfig(); err != nil {
+		report.fail("", "", "", err)
+		return
 	}
 
-	for {
-		// Get the first page with projectsChan.
-		projects, response, err := gitLabClient.Projects.ListProjects(listProjectsOptions)
-		CheckPanic(err)
+	gitBackend, err = newGit(config.GitBackend)
+	if err != nil {
+		report.fail("", "", "", err)
+		return
+	}
 
-		// List all the projectsChan we've found so far.
-		for _, project := range projects {
-			projectsChan <- project
-		}
+	state, err = loadState(config.StateFile)
+	if err != nil {
+		report.fail("", "", config.StateFile, fmt.Errorf("load state: %w", err))
+		return
+	}
 
-		// Exit the loop when we've seen all pages.
-		if response.CurrentPage >= response.TotalPages {
-			break
+	selection := selectionHash()
+	filters := config.Filters
+	if config.Incremental {
+		var reason string
+		filters.LastActivityAfter, reason = state.lastActivityAfter(selection)
+		log.WithFields(logrus.Fields{
+			"lastActivityAfter": filters.LastActivityAfter,
+			"listingAllBecause": reason,
+		}).Info("Running in incremental mode")
+	}
+
+	var apiRetry *RetryConfig
+	apiRetry, gitRetry = newRetries()
+
+	hosting, err = provider.New(provider.Config{
+		Kind:        config.Provider,
+		URL:         config.GitLabURL,
+		APIURL:      config.GitLabAPIURL,
+		Token:       token.Reveal(),
+		PerPage:     config.ListOptionsPerPage,
+		Groups:      config.Groups,
+		Filters:     filters,
+		Retry:       apiRetry,
+		Concurrency: config.CloneThreadsCount,
+	})
+	if err != nil {
+		report.fail("", "", "", err)
+		return
+	}
+
+	gitEnv, gitAuth = nil, nil
+	if config.CloneProtocol == provider.ProtocolHTTPS {
+		cleanup, err := setupHTTPSCredentials(hosting.Credentials())
+		if err != nil {
+			report.fail("", "", "", fmt.Errorf("setup https credentials: %w", err))
+			return
 		}
+		defer cleanup()
+	}
+
+	projectsChan := make(chan *provider.Project, config.CloneThreadsCount)
+	for i := 0; i < config.CloneThreadsCount; i++ {
+		waitGroup.Add(1)
+		go addProject(projectsChan, &waitGroup)
+	}
 
-		// Update the page number to get the next page.
-		listProjectsOptions.Page = response.NextPage
+	err = hosting.ListProjects(projectsChan)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"err": err,
+		}).Error("list projects failed")
+		report.fail("", "", "", fmt.Errorf("list projects: %w", err))
 	}
+	projectsListed = err == nil
 
 	close(projectsChan)
 	log.Debug("All repos found, now waiting for cloning them ...")
 	waitGroup.Wait()
+
+	if config.Prune {
+		prune()
+	}
+
+	if !config.DryRun {
+		// Projects failed this time must be listed on the next run again
+		if report.Count(StatusFailed) == failedBefore {
+			state.finishRun(runStart, selection, projectsListed && filters.LastActivityAfter == nil)
+		}
+		if err := state.save(config.StateFile); err != nil {
+			report.fail("", "", config.StateFile, fmt.Errorf("save state: %w", err))
+		}
+	}
 }
 
-func addProject(projectsPtr <-chan *gitlab.Project, waitGroup *sync.WaitGroup) {
+func addProject(projectsPtr <-chan *provider.Project, waitGroup *sync.WaitGroup) {
 	for projectPtr := range projectsPtr {
-		repoPath := strings.ReplaceAll(projectPtr.WebURL, config.GitLabURL+config.RootRemove, "")
+		projectPath := strings.ReplaceAll(projectPtr.WebURL, config.GitLabURL+config.RootRemove, "")
 
 		if config.RootRemove != "" {
-			repoPath = strings.ReplaceAll(repoPath, config.RootRemove, "")
+			projectPath = strings.ReplaceAll(projectPath, config.RootRemove, "")
+		}
+
+		repoPath, pathFields, err := getRepoPath(projectPtr, projectPath)
+		if err != nil {
+			report.Plan.addProject(&PlanProject{Path: projectPath, WebURL: projectPtr.WebURL, SkippedBy: err.Error()})
+			report.fail(projectPath, "*", projectPath, err)
+			continue
 		}
 
 		log.WithFields(logrus.Fields{
 			"repo": repoPath,
 		}).Debug("project found")
+		rememberUpstreamRepo(repoPath)
+
+		projectPlan := &PlanProject{Path: repoPath, WebURL: projectPtr.WebURL}
+		report.Plan.addProject(projectPlan)
 
-		if !checkSkipCloneRegexps(&reposSkipCloneRegexList, repoPath) {
+		projectPlan.MatchedBy, projectPlan.SkippedBy, projectPlan.Matched = matchSkipCloneRegexps(&reposSkipCloneRegexList, repoPath)
+		if projectPlan.Matched {
+			if reason := matchRepoMetadata(projectPtr); reason != "" {
+				projectPlan.SkippedBy, projectPlan.Matched = reason, false
+			}
+		}
+		if !projectPlan.Matched {
 			log.WithFields(logrus.Fields{
-				"repo": repoPath,
+				"reason": projectPlan.SkippedBy,
+				"repo":   repoPath,
 			}).Info("repo skipped")
-		} else {
+			report.projectSeen(true)
+			continue
+		}
+		report.projectSeen(false)
+
+		if err := claimRepoPath(repoPath, pathFields); err != nil {
+			report.fail(repoPath, "*", repoPath, err)
+			continue
+		}
+
+		if config.Incremental && state.projectUnchanged(repoPath, projectPtr.LastActivityAt) && pathExists(repoPath) {
 			log.WithFields(logrus.Fields{
 				"repo": repoPath,
-			}).Info("repo clone started")
-
-			// Unused, not needed now
-			//if config.DetectMultiBranchFileName != "" {
-			//	getFileMetaDataOptions := &gitlab.GetFileMetaDataOptions{
-			//		Ref: gitlab.String(projectPtr.DefaultBranch),
-			//	}
-			//	_, _, err := gitLabClient.RepositoryFiles.GetFileMetaData(projectPtr.ID, config.DetectMultiBranchFileName, getFileMetaDataOptions)
-			//	if err == nil {
-			//		addMultiBranchRepo(projectPtr.ID, repoPath, projectPtr.SSHURLToRepo)
-			//	}
-			//}
-
-			if config.ExpandBranches {
-				addMultiBranchRepo(repoPath, projectPtr)
-			} else {
-				addSingleBranchRepo(repoPath, projectPtr.SSHURLToRepo, projectPtr.DefaultBranch, true, "")
-			}
+			}).Info("repo unchanged since last run")
+			projectPlan.SkippedBy = "unchanged since last run"
+			report.skip(repoPath, "*", repoPath, projectPlan.SkippedBy)
+			state.keepProject(repoPath)
+			continue
+		}
+
+		log.WithFields(logrus.Fields{
+			"repo": repoPath,
+		}).Info("repo clone started")
+		state.startProject(repoPath)
+
+		cloneURL, err := hosting.CloneURL(projectPtr, config.CloneProtocol)
+		if err != nil {
+			report.fail(repoPath, "", repoPath, err)
+			continue
+		}
+		projectPlan.CloneURL = cloneURL
+
+		var synced bool
+		switch {
+		case config.Layout == LayoutMirror:
+			synced = addMirrorRepo(repoPath, cloneURL, projectPtr, projectPlan)
+		case config.ExpandBranches:
+			synced = addMultiBranchRepo(repoPath, cloneURL, projectPtr, projectPlan)
+		default:
+			synced = addSingleBranchRepo(repoPath, cloneURL, projectPtr.DefaultBranch, defaultBranchCommit(repoPath, projectPtr), true, "", projectPlan) == nil
+		}
+		if synced {
+			state.rememberProject(repoPath, projectPtr.LastActivityAt)
 		}
 	}
 	waitGroup.Done()
 }
 
 func checkSkipCloneRegexps(regexpsPtr *SkipCloneRegexStruct, str string) bool {
-	cloneProject := false
+	_, _, matched := matchSkipCloneRegexps(regexpsPtr, str)
+	return matched
+}
+
+// matchSkipCloneRegexps returns clone regexp matched str and reason str was skipped
+func matchSkipCloneRegexps(regexpsPtr *SkipCloneRegexStruct, str string) (string, string, bool) {
+	var matchedBy string
 
 	for _, regexp := range regexpsPtr.Clone {
 		if regexp.MatchString(str) {
-			cloneProject = true
+			matchedBy = regexp.String()
 			log.WithFields(logrus.Fields{
 				"regexp": regexp.String(),
 				"str":    str,
@@ -241,11 +493,11 @@ func checkSkipCloneRegexps(regexpsPtr *SkipCloneRegexStruct, str string) bool {
 		}
 	}
 
-	if !cloneProject {
+	if matchedBy == "" {
 		log.WithFields(logrus.Fields{
 			"str": str,
 		}).Trace("didn't match any clone regexp")
-		return false
+		return "", "didn't match any clone regexp", false
 	}
 
 	for _, regexp := range regexpsPtr.Skip {
@@ -254,71 +506,166 @@ func checkSkipCloneRegexps(regexpsPtr *SkipCloneRegexStruct, str string) bool {
 				"regexp": regexp.String(),
 				"str":    str,
 			}).Trace("skipped due to skip regexp")
-			return false
+			return matchedBy, "skip regexp " + regexp.String(), false
 		}
 	}
 
-	return true
+	return matchedBy, "", true
 }
 
-func addMultiBranchRepo(repoPath string, projectPtr *gitlab.Project) {
-	listBranchesOptions := &gitlab.ListBranchesOptions{
-		ListOptions: gitlab.ListOptions{
-			PerPage: config.ListOptionsPerPage,
-			Page:    1,
-		},
+// addMultiBranchRepo returns true when all branches of the repo were synced successfully
+func addMultiBranchRepo(repoPath string, cloneURL string, projectPtr *provider.Project, projectPlan *PlanProject) bool {
+	branches, err := hosting.ListBranches(projectPtr)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"err":  err,
+			"repo": repoPath,
+		}).Error("list branches failed")
+		report.fail(repoPath, "*", repoPath, fmt.Errorf("list branches: %w", err))
+		return false
 	}
 
-	addSingleBranchRepo(repoPath, projectPtr.SSHURLToRepo, projectPtr.DefaultBranch, true, "")
+	defaultBranch := &provider.Branch{Name: projectPtr.DefaultBranch, Default: true}
+	branchPaths := []string{getBranchPath(repoPath, defaultBranch.Name)}
+	for _, branch := range branches {
+		if branch.Default {
+			defaultBranch = branch
+		}
+		branchPaths = append(branchPaths, getBranchPath(repoPath, branch.Name))
+	}
 
-	for {
-		// Get the first page with Branches.
-		branches, resp, err := gitLabClient.Branches.ListBranches(projectPtr.ID, listBranchesOptions)
-		CheckPanic(err)
+	var tags []*provider.Tag
+	if tagsEnabled() {
+		if tags, err = hosting.ListTags(projectPtr); err != nil {
+			report.fail(repoPath, "*", repoPath, fmt.Errorf("list tags: %w", err))
+			return false
+		}
+		// Tag worktrees are pruned like branch ones when the tag is deleted upstream
+		for _, tag := range tags {
+			branchPaths = append(branchPaths, getTagPath(repoPath, tag.Name))
+		}
+	}
 
-		// List all the Branches we've found so far.
-		for _, branch := range branches {
-			if !branch.Default {
-				addSingleBranchRepo(repoPath, projectPtr.SSHURLToRepo, branch.Name, branch.Default, projectPtr.DefaultBranch)
-			}
+	var mergeRequests []*provider.MergeRequest
+	if config.Branches.OpenMergeRequest || mergeRequestsEnabled() {
+		if mergeRequests, err = hosting.ListMergeRequests(projectPtr); err != nil {
+			report.fail(repoPath, "*", repoPath, fmt.Errorf("list merge requests: %w", err))
+			return false
+		}
+	}
+	if mergeRequestsEnabled() {
+		// Worktrees of merge requests closed or merged upstream are pruned
+		for _, mergeRequest := range mergeRequests {
+			branchPaths = append(branchPaths, getMergeRequestPath(repoPath, mergeRequest))
 		}
+	}
+	rememberUpstreamBranches(repoPath, branchPaths)
 
-		// Exit the loop when we've seen all pages.
-		if resp.CurrentPage >= resp.TotalPages {
-			break
+	skipReasons := selectBranches(branches, mergeRequests)
+	if err := checkWorktreePaths(repoPath, defaultBranch.Name, branches, skipReasons, tags, mergeRequests); err != nil {
+		report.fail(repoPath, "*", repoPath, err)
+		return false
+	}
+
+	// Worktrees are updated on their own even if the default branch has local changes
+	if err := addSingleBranchRepo(repoPath, cloneURL, defaultBranch.Name, defaultBranch.CommitID, true, "", projectPlan); err != nil && !errors.Is(err, errLocalChanges) {
+		report.skip(repoPath, "*", repoPath, "default branch "+projectPtr.DefaultBranch+" failed")
+		return false
+	}
+
+	synced := true
+	for _, branch := range branches {
+		if reason, skipped := skipReasons[branch.Name]; skipped {
+			skipBranch(repoPath, branch.Name, reason, projectPlan)
+			continue
+		}
+		if !branch.Default {
+			if err := addSingleBranchRepo(repoPath, cloneURL, branch.Name, branch.CommitID, branch.Default, projectPtr.DefaultBranch, projectPlan); err != nil {
+				synced = false
+			}
 		}
+	}
 
-		// Update the page number to get the next page.
-		listBranchesOptions.Page = resp.NextPage
+	if len(tags) > 0 && !addTags(repoPath, defaultBranch.Name, tags, projectPlan) {
+		synced = false
+	}
+	if mergeRequestsEnabled() && !addMergeRequests(repoPath, defaultBranch.Name, mergeRequests, projectPlan) {
+		synced = false
 	}
+	return synced
 }
 
-func addSingleBranchRepo(repoPath string, SSHURLToRepo string, branch string, isDefaultBranch bool, defaultBranch string) {
-	var branchSlug, branchPath string
-
-	if config.ExpandBranches == true {
-		branchSlug = getBranchSlug(branch)
-		branchPath = strings.Join([]string{
-			repoPath,
-			"/",
-			config.Branches.Prefix,
-			branchSlug,
-		}, "")
-	} else {
-		branchPath = repoPath
+// defaultBranchCommit returns head commit of the default branch for incremental mode, projects list
+// doesn't have it. It is empty otherwise or when the branch can't be got, then the branch is synced anyway
+func defaultBranchCommit(repoPath string, projectPtr *provider.Project) string {
+	if !config.Incremental {
+		return ""
+	}
+	if projectPtr.DefaultBranch == "" {
+		// Empty repo has no branches
+		return ""
 	}
+	branch, err := hosting.GetBranch(projectPtr, projectPtr.DefaultBranch)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"err":  err,
+			"repo": repoPath,
+		}).Warn("get default branch failed, it is synced")
+		return ""
+	}
+	return branch.CommitID
+}
+
+// skipBranch records branch skipped by branches rules
+func skipBranch(repoPath string, branch string, reason string, projectPlan *PlanProject) {
+	branchPath := getBranchPath(repoPath, branch)
+
+	log.WithFields(logrus.Fields{
+		"branch":     branch,
+		"branchPath": branchPath,
+		"reason":     reason,
+		"repoPath":   repoPath,
+	}).Debug("branch skipped")
+
+	projectPlan.addBranch(&PlanBranch{Name: branch, Slug: getBranchSlug(branch), Path: branchPath, SkippedBy: reason})
+	report.skip(repoPath, branch, branchPath, reason)
+}
+
+// addSingleBranchRepo clones or updates the branch and records the outcome into the report
+func addSingleBranchRepo(repoPath string, cloneURL string, branch string, commitID string, isDefaultBranch bool, defaultBranch string, projectPlan *PlanProject) error {
+	branchPath := getBranchPath(repoPath, branch)
+
+	branchPlan := &PlanBranch{Name: branch, Path: branchPath, Default: isDefaultBranch, Matched: true}
+	projectPlan.addBranch(branchPlan)
 
 	if config.ExpandBranches {
-		if !checkSkipCloneRegexps(&branchesSkipCloneRegexList, branch) {
+		branchPlan.Slug = getBranchSlug(branch)
+		branchPlan.MatchedBy, branchPlan.SkippedBy, branchPlan.Matched = matchSkipCloneRegexps(&branchesSkipCloneRegexList, branch)
+		if !branchPlan.Matched {
 			log.WithFields(logrus.Fields{
 				"branch":     branch,
 				"branchPath": branchPath,
 				"repoPath":   repoPath,
 			}).Debug("branch skipped")
 
-			return
+			report.skip(repoPath, branch, branchPath, "didn't pass branches regexps")
+			return nil
 		}
 	}
+	// Path of the branch synced, unchanged or having local changes is checked on the next run
+	defer state.rememberPath(repoPath, branchPath)
+
+	if config.Incremental && state.branchUnchanged(repoPath, branch, commitID) && pathExists(branchPath) {
+		log.WithFields(logrus.Fields{
+			"branch":     branch,
+			"branchPath": branchPath,
+			"repoPath":   repoPath,
+		}).Debug("branch unchanged since last run")
+
+		branchPlan.SkippedBy = "unchanged since last run"
+		report.skip(repoPath, branch, branchPath, branchPlan.SkippedBy)
+		return nil
+	}
 
 	log.WithFields(logrus.Fields{
 		"repoPath":      repoPath,
@@ -327,27 +674,56 @@ func addSingleBranchRepo(repoPath string, SSHURLToRepo string, branch string, is
 		"defaultBranch": defaultBranch,
 	}).Debug("branch clone started")
 
-	_, err := os.Stat(branchPath)
-	if os.IsNotExist(err) {
-		if isDefaultBranch {
-			gitMutex.Lock()
-			runCommand("./", "git", "submodule", "add", "--force", "-b", branch, SSHURLToRepo, branchPath)
-			gitMutex.Unlock()
-		} else {
-			defaultBranchPath := repoPath + "/" + config.Branches.Prefix + getBranchSlug(defaultBranch)
-			runCommand(defaultBranchPath,
-				"git", "worktree", "add", "../"+config.Branches.Prefix+branchSlug, branch)
-		}
+	outcome, err := syncBranch(newPlanGit(&branchPlan.Actions), repoPath, cloneURL, branch, branchPath, isDefaultBranch, defaultBranch)
+	if note := retriesNote(branchPlan.Actions); note != "" && err == nil {
+		outcome += ", " + note
+	}
+	if errors.Is(err, errLocalChanges) {
+		branchPlan.SkippedBy = err.Error()
+		report.skip(repoPath, branch, branchPath, err.Error())
+		return err
+	}
+	if err != nil {
+		report.fail(repoPath, branch, branchPath, err)
+		return err
+	}
+	report.success(repoPath, branch, branchPath, outcome)
+	state.rememberBranch(repoPath, branch, commitID)
+	return nil
+}
+
+// syncBranch creates missing branch path or updates existing one and returns what was done
+func syncBranch(git Git, repoPath string, cloneURL string, branch string, branchPath string, isDefaultBranch bool, defaultBranch string) (string, error) {
+	if pathExists(branchPath) {
+		return updateBranch(git, branchPath, branch)
+	}
+
+	var outcome string
+	var err error
+	options := getCloneOptions(repoPath)
+	if isDefaultBranch && config.Layout == LayoutClones {
+		outcome, err = dryRunOutcome("cloned"), git.Clone(cloneURL, branch, branchPath, options)
+	} else if isDefaultBranch {
+		outcome, err = dryRunOutcome("submodule added"), git.SubmoduleAdd(cloneURL, branch, branchPath, options)
+	} else {
+		outcome, err = dryRunOutcome("worktree added"), git.WorktreeAdd(getBranchPath(repoPath, defaultBranch), branchPath, branch, options)
+	}
+	if err != nil {
+		return "", err
 	}
-	runCommand(branchPath, "git", "checkout", branch)
-	runCommand(branchPath, "git", "pull")
+	return outcome, git.Checkout(branchPath, branch)
+}
+
+func pathExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
 }
 
 func getBranchSlug(str string) string {
-	return strings.ReplaceAll(str, "/", config.Branches.Slash)
+	return encodeSlug(str, config.Branches.Slash)
 }
 
-func runCommand(path string, command string, args ...string) {
+func runCommand(path string, command string, args ...string) error {
 	log.WithFields(logrus.Fields{
 		"args": args,
 		"cmd":  command,
@@ -355,29 +731,26 @@ func runCommand(path string, command string, args ...string) {
 	}).Trace("runCommand: start")
 
 	if config.DryRun {
-		return
+		return nil
 	}
 
 	cmd := exec.Command(command, args...)
 	cmd.Dir = path
+	cmd.Env = append(os.Environ(), gitEnv...)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
 
 	// And when you need to wait for the command to finish:
 	if err := cmd.Run(); err != nil {
-		//if err.Error() == "exit status 128" {
-		//	log.WithFields(logrus.Fields{
-		//		"args": args,
-		//		"cmd":  command,
-		//		"err":  err,
-		//		"path": path,
-		//	}).Debug("runCommand: returned error")
-		//} else {
 		log.WithFields(logrus.Fields{
-			"args": args,
-			"cmd":  command,
-			"err":  err,
-			"path": path,
+			"args":   args,
+			"cmd":    command,
+			"err":    err,
+			"path":   path,
+			"stderr": strings.TrimSpace(stderr.String()),
 		}).Error("runCommand: returned error")
-		//}
+		return commandError(command, args, err, stderr.String())
 	}
 
 	log.WithFields(logrus.Fields{
@@ -385,4 +758,36 @@ func runCommand(path string, command string, args ...string) {
 		"cmd":  command,
 		"path": path,
 	}).Trace("runCommand: end")
+	return nil
+}
+
+// runOutput runs read only command and returns its stdout, it runs in dry run mode too
+func runOutput(path string, command string, args ...string) (string, error) {
+	log.WithFields(logrus.Fields{
+		"args": args,
+		"cmd":  command,
+		"path": path,
+	}).Trace("runOutput: start")
+
+	cmd := exec.Command(command, args...)
+	cmd.Dir = path
+	cmd.Env = append(os.Environ(), gitEnv...)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		return "", commandError(command, args, err, stderr.String())
+	}
+	return string(output), nil
+}
+
+// commandError keeps the last stderr line, git puts the reason of failure there
+func commandError(command string, args []string, err error, stderr string) error {
+	lines := strings.Split(strings.TrimSpace(stderr), "\n")
+	if lastLine := strings.TrimSpace(lines[len(lines)-1]); lastLine != "" {
+		return fmt.Errorf("%s %s: %w: %s", command, strings.Join(args, " "), err, lastLine)
+	}
+	return fmt.Errorf("%s %s: %w", command, strings.Join(args, " "), err)
 }
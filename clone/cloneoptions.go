@@ -0,0 +1,103 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package clone
+
+import (
+	re "regexp"
+	"strconv"
+	"strings"
+)
+
+// CloneOptionsStruct is a rule of clone options for repos matched by any of Repos regexps,
+// the first matched rule applies
+type CloneOptionsStruct struct {
+	Repos []string
+	// Filter is a partial clone filter like blob:none
+	Filter string
+	Depth  int
+	// Sparse are directories checked out in cone mode besides files of the root
+	Sparse []string
+}
+
+// CloneOptions make partial, shallow or sparse clones and worktrees, nil makes full ones
+type CloneOptions struct {
+	Filter string
+	Depth  int
+	Sparse []string
+	// AllBranches makes shallow clone fetch all branches, their worktrees need them
+	AllBranches bool
+}
+
+var (
+	cloneOptionsRegexList [][]*re.Regexp
+	cloneFilterRegexp     = re.MustCompile(`^(blob:none|blob:limit=\d+[kmg]?|tree:\d+)$`)
+)
+
+func compileCloneOptionsRegexps() {
+	cloneOptionsRegexList = nil
+	for _, rule := range config.CloneOptions {
+		cloneOptionsRegexList = append(cloneOptionsRegexList, compileSkipCloneRegexps(rule.Repos))
+	}
+}
+
+// getCloneOptions returns options of the first rule matching repoPath, nil if none does
+func getCloneOptions(repoPath string) *CloneOptions {
+	for i, regexps := range cloneOptionsRegexList {
+		if matchAnyRegexp(regexps, repoPath) {
+			rule := config.CloneOptions[i]
+			return &CloneOptions{
+				Filter:      rule.Filter,
+				Depth:       rule.Depth,
+				Sparse:      rule.Sparse,
+				AllBranches: config.ExpandBranches,
+			}
+		}
+	}
+	return nil
+}
+
+func (o *CloneOptions) empty() bool {
+	return o == nil || o.Filter == "" && o.Depth == 0 && len(o.Sparse) == 0
+}
+
+// args returns arguments of git clone, sparse checkout is set after cloning
+func (o *CloneOptions) args() []string {
+	var args []string
+	if o.Filter != "" {
+		args = append(args, "--filter="+o.Filter)
+	}
+	if o.Depth > 0 {
+		args = append(args, "--depth", strconv.Itoa(o.Depth))
+		if o.AllBranches {
+			args = append(args, "--no-single-branch")
+		}
+	}
+	return args
+}
+
+// planArgs describes options in plan actions
+func (o *CloneOptions) planArgs() []string {
+	if o.empty() {
+		return nil
+	}
+	args := o.args()
+	if len(o.Sparse) > 0 {
+		args = append(args, "--sparse="+strings.Join(o.Sparse, ","))
+	}
+	return args
+}
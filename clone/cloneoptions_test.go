@@ -0,0 +1,91 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package clone
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGetCloneOptions(t *testing.T) {
+	configPtr := &ConfigStruct{
+		ExpandBranches: true,
+		CloneOptions: []*CloneOptionsStruct{
+			{Repos: []string{"^big/monorepo$"}, Filter: "blob:none", Sparse: []string{"services/api"}},
+			{Repos: []string{"^big/"}, Depth: 1},
+			{Repos: []string{"^big/history$"}, Filter: "tree:0"},
+		},
+	}
+	setTestConfig(t, configPtr)
+	compileCloneOptionsRegexps()
+	t.Cleanup(func() {
+		cloneOptionsRegexList = nil
+	})
+
+	tests := []struct {
+		repo     string
+		empty    bool
+		planArgs string
+	}{
+		{"big/monorepo", false, "--filter=blob:none --sparse=services/api"},
+		// The first matched rule applies
+		{"big/history", false, "--depth 1 --no-single-branch"},
+		{"small/tool", true, ""},
+	}
+	for _, test := range tests {
+		options := getCloneOptions(test.repo)
+		if options.empty() != test.empty {
+			t.Errorf("getCloneOptions(%s) = %+v, want empty %v", test.repo, options, test.empty)
+		}
+		if planArgs := strings.Join(options.planArgs(), " "); planArgs != test.planArgs {
+			t.Errorf("planArgs of %s = %q, want %q", test.repo, planArgs, test.planArgs)
+		}
+	}
+
+	configPtr.ExpandBranches = false
+	if args := strings.Join(getCloneOptions("big/tool").args(), " "); args != "--depth 1" {
+		t.Errorf("shallow clone without worktrees args %q, want single branch", args)
+	}
+}
+
+func TestCloneSparse(t *testing.T) {
+	setTestConfig(t, &ConfigStruct{})
+	chdirTemp(t)
+	initClone(t, "upstream")
+	for _, path := range []string{"README.md", "services/api/main.go", "services/web/index.html", "docs/guide.md"} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join("upstream", path)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join("upstream", path), []byte(path), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runGit(t, "upstream", "add", ".")
+	runGit(t, "upstream", "commit", "--quiet", "-m", "files")
+
+	options := &CloneOptions{Sparse: []string{"services/api"}}
+	if err := (&binaryGit{}).Clone("upstream", "main", "work", options); err != nil {
+		t.Fatal(err)
+	}
+	checkPaths(t, []string{"work/README.md", "work/services/api/main.go"}, []string{"work/services/web", "work/docs"})
+	if dirty, err := isDirty("work"); err != nil || dirty {
+		t.Errorf("sparse clone is dirty %v: %v", dirty, err)
+	}
+}
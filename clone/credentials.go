@@ -0,0 +1,177 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package clone
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	. "github.com/Logunov/heydevops/helpers"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+const (
+	askPassUsernameEnv = "HEYDEVOPS_GIT_USERNAME"
+	askPassPasswordEnv = "HEYDEVOPS_GIT_PASSWORD"
+
+	// askPassScript answers git prompts from environment, so the token is never written to disk
+	askPassScript = `#!/bin/sh
+case "$1" in
+Username*) echo "$` + askPassUsernameEnv + `" ;;
+*) echo "$` + askPassPasswordEnv + `" ;;
+esac
+`
+)
+
+// Prefixes of token references, token without any of them is the token itself
+const (
+	TokenCommand    = "cmd:"
+	TokenFile       = "file:"
+	TokenEnv        = "env:"
+	TokenCredential = "credential:"
+)
+
+var (
+	// gitEnv is added to environment of every git command
+	gitEnv []string
+	// gitAuth is used by go-git backend for network operations
+	gitAuth transport.AuthMethod
+)
+
+// setupHTTPSCredentials makes git authenticate with the token over HTTPS via GIT_ASKPASS,
+// returned function removes the askpass script
+func setupHTTPSCredentials(username string, password string) (func(), error) {
+	askPassFile, err := os.CreateTemp("", "heydevops-askpass-*")
+	if err != nil {
+		return nil, err
+	}
+	cleanup := func() {
+		_ = os.Remove(askPassFile.Name())
+	}
+
+	if _, err := askPassFile.WriteString(askPassScript); err != nil {
+		_ = askPassFile.Close()
+		cleanup()
+		return nil, err
+	}
+	if err := askPassFile.Close(); err != nil {
+		cleanup()
+		return nil, err
+	}
+	if err := os.Chmod(askPassFile.Name(), 0700); err != nil {
+		cleanup()
+		return nil, err
+	}
+
+	gitEnv = []string{
+		"GIT_ASKPASS=" + askPassFile.Name(),
+		"GIT_TERMINAL_PROMPT=0",
+		askPassUsernameEnv + "=" + username,
+		askPassPasswordEnv + "=" + password,
+	}
+	// Empty credential.helper disables configured helpers, so they don't store the token
+	gitEnv = append(gitEnv, appendGitConfigEnv("credential.helper", "")...)
+	gitAuth = &http.BasicAuth{Username: username, Password: password}
+
+	return cleanup, nil
+}
+
+// appendGitConfigEnv returns environment adding the config entry after entries the user passed
+// via GIT_CONFIG_COUNT, so they keep applying
+func appendGitConfigEnv(key string, value string) []string {
+	count, err := strconv.Atoi(os.Getenv("GIT_CONFIG_COUNT"))
+	if err != nil || count < 0 {
+		count = 0
+	}
+	return []string{
+		fmt.Sprintf("GIT_CONFIG_COUNT=%d", count+1),
+		fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", count, key),
+		fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", count, value),
+	}
+}
+
+// resolveToken returns the token the reference points to: output of the command, content of the file,
+// value of the environment variable or password git credential helper has for the URL
+func resolveToken(reference Secret, hostingURL string) (Secret, error) {
+	value := reference.Reveal()
+	switch {
+	case strings.HasPrefix(value, TokenCommand):
+		cmd := exec.Command("sh", "-c", strings.TrimPrefix(value, TokenCommand))
+		// Password managers may ask for passphrase
+		cmd.Stdin, cmd.Stderr = os.Stdin, os.Stderr
+		output, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("token command: %w", err)
+		}
+		return Secret(strings.TrimSpace(string(output))), nil
+	case strings.HasPrefix(value, TokenFile):
+		content, err := os.ReadFile(strings.TrimPrefix(value, TokenFile))
+		if err != nil {
+			return "", fmt.Errorf("token file: %w", err)
+		}
+		return Secret(strings.TrimSpace(string(content))), nil
+	case strings.HasPrefix(value, TokenEnv):
+		name := strings.TrimPrefix(value, TokenEnv)
+		token, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("token environment variable %s isn't set", name)
+		}
+		return Secret(strings.TrimSpace(token)), nil
+	case strings.HasPrefix(value, TokenCredential):
+		credentialURL := strings.TrimPrefix(value, TokenCredential)
+		if credentialURL == "" {
+			credentialURL = hostingURL
+		}
+		return credentialFill(credentialURL)
+	default:
+		return reference, nil
+	}
+}
+
+// credentialFill asks git credential helpers for password of the URL, git never prompts for it
+func credentialFill(credentialURL string) (Secret, error) {
+	parsedURL, err := url.Parse(credentialURL)
+	if err != nil {
+		return "", fmt.Errorf("credential helper: %w", err)
+	}
+
+	cmd := exec.Command("git", "credential", "fill")
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_ASKPASS=", "SSH_ASKPASS=")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("protocol=%s\nhost=%s\n\n", parsedURL.Scheme, parsedURL.Host))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("credential helper has no token for %s: %w: %s", parsedURL.Host, err, strings.TrimSpace(stderr.String()))
+	}
+
+	for _, line := range strings.Split(string(output), "\n") {
+		if password, found := strings.CutPrefix(line, "password="); found {
+			return Secret(password), nil
+		}
+	}
+	return "", errors.New("credential helper returned no password for " + parsedURL.Host)
+}
@@ -0,0 +1,99 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package clone
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/Logunov/heydevops/helpers"
+)
+
+func TestResolveToken(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("  from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HEYDEVOPS_TEST_TOKEN", " from-env ")
+	// Helper answers for gitlab.corp only, global and system ones are ignored
+	t.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_CONFIG_COUNT", "1")
+	t.Setenv("GIT_CONFIG_KEY_0", "credential.helper")
+	t.Setenv("GIT_CONFIG_VALUE_0", `!f() { while read line && [ -n "$line" ]; do [ "$line" = host=gitlab.corp ] && found=1; done; [ -n "$found" ] && echo username=oauth2 && echo password=from-helper; true; }; f`)
+
+	tests := []struct {
+		name      string
+		reference Secret
+		token     string
+		fails     bool
+	}{
+		{"plain token", "glpat-plain", "glpat-plain", false},
+		{"empty", "", "", false},
+		{"command", "cmd:printf ' from-cmd\\n'", "from-cmd", false},
+		{"command failed", "cmd:exit 3", "", true},
+		{"file", Secret(TokenFile + tokenFile), "from-file", false},
+		{"missing file", Secret(TokenFile + tokenFile + ".missing"), "", true},
+		{"environment", "env:HEYDEVOPS_TEST_TOKEN", "from-env", false},
+		{"unset environment", "env:HEYDEVOPS_TEST_UNSET", "", true},
+		{"credential of hosting", "credential:", "from-helper", false},
+		{"credential of url", "credential:https://gitlab.corp/group", "from-helper", false},
+		{"no credential", "credential:https://gitlab.com/", "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			token, err := resolveToken(test.reference, "https://gitlab.corp/")
+			if test.fails != (err != nil) {
+				t.Fatalf("resolveToken(%q) error %v, want failure %v", test.reference.Reveal(), err, test.fails)
+			}
+			if token.Reveal() != test.token {
+				t.Errorf("resolveToken(%q) = %q, want %q", test.reference.Reveal(), token.Reveal(), test.token)
+			}
+		})
+	}
+}
+
+func TestSetupHTTPSCredentials(t *testing.T) {
+	setTestConfig(t, &ConfigStruct{})
+	savedEnv, savedAuth := gitEnv, gitAuth
+	t.Cleanup(func() {
+		gitEnv, gitAuth = savedEnv, savedAuth
+	})
+	t.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_CONFIG_COUNT", "2")
+	t.Setenv("GIT_CONFIG_KEY_0", "user.name")
+	t.Setenv("GIT_CONFIG_VALUE_0", "someone")
+	t.Setenv("GIT_CONFIG_KEY_1", "credential.helper")
+	t.Setenv("GIT_CONFIG_VALUE_1", "store")
+
+	cleanup, err := setupHTTPSCredentials("oauth2", "glpat-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	// The user's entries keep applying, the empty helper goes after them
+	if name, err := runOutput(t.TempDir(), "git", "config", "--get", "user.name"); err != nil || name != "someone\n" {
+		t.Errorf("user.name %q, want the one passed by the user: %v", name, err)
+	}
+	if helpers, err := runOutput(t.TempDir(), "git", "config", "--get-all", "credential.helper"); err != nil || helpers != "store\n\n" {
+		t.Errorf("credential.helper %q, want store reset by the empty one: %v", helpers, err)
+	}
+}
@@ -0,0 +1,243 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package clone
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ExecResult is an outcome of running the command in single path
+type ExecResult struct {
+	Repo     string `json:"repo" yaml:"repo"`
+	Branch   string `json:"branch,omitempty" yaml:"branch,omitempty"`
+	Path     string `json:"path" yaml:"path"`
+	Status   string `json:"status" yaml:"status"`
+	ExitCode int    `json:"exit_code" yaml:"exit_code"`
+	Output   string `json:"output" yaml:"output"`
+	Cause    string `json:"cause,omitempty" yaml:"cause,omitempty"`
+}
+
+// execRun collects results of Exec over all sources, with failFast paths not started yet are skipped
+// after the first failure
+type execRun struct {
+	command  []string
+	failFast bool
+	mutex    sync.Mutex
+	results  []*ExecResult
+	failed   bool
+}
+
+func (r *execRun) add(result *ExecResult) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.results = append(r.results, result)
+	if result.Status == StatusFailed {
+		r.failed = true
+	}
+}
+
+func (r *execRun) stopped() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.failFast && r.failed
+}
+
+// Exec runs command in every local path of every source matched by repos and branches regexps,
+// with failFast paths not started yet are skipped after the first failure
+func Exec(command []string, failFast bool) ([]*ExecResult, error) {
+	if len(command) == 0 {
+		return nil, errors.New("no command to run")
+	}
+
+	run := &execRun{command: command, failFast: failFast, results: []*ExecResult{}}
+	err := walkSources(func(subdir string) error {
+		resultsStart := len(run.results)
+		if err := run.execLocalRepos(); err != nil {
+			return err
+		}
+		for _, result := range run.results[resultsStart:] {
+			result.Path = filepath.Join(subdir, result.Path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(run.results, func(i, j int) bool {
+		return run.results[i].Path < run.results[j].Path
+	})
+	return run.results, nil
+}
+
+// execLocalRepos runs command in every matched path of the local layout in the current directory in parallel
+func (r *execRun) execLocalRepos() error {
+	repos, err := findLocalRepos()
+	if err != nil {
+		return err
+	}
+
+	pathsChan := make(chan *ExecResult)
+	var execWaitGroup sync.WaitGroup
+	for i := 0; i < config.CloneThreadsCount; i++ {
+		execWaitGroup.Add(1)
+		go func() {
+			defer execWaitGroup.Done()
+			for result := range pathsChan {
+				if r.stopped() {
+					result.Status = StatusSkipped
+					result.Cause = "stopped after the first failure"
+				} else {
+					runExec(result, r.command)
+				}
+				r.add(result)
+			}
+		}()
+	}
+
+	for _, repo := range repos {
+		if !checkSkipCloneRegexps(&reposSkipCloneRegexList, repo.RepoPath) {
+			continue
+		}
+
+		if !config.ExpandBranches {
+			pathsChan <- &ExecResult{Repo: repo.RepoPath, Path: repo.MainPath}
+			continue
+		}
+
+		mainEntry := &worktreeEntry{Path: repo.MainPath, Detached: true}
+		if branch, err := runOutput(repo.MainPath, "git", "symbolic-ref", "--short", "-q", "HEAD"); err == nil {
+			mainEntry.Branch, mainEntry.Detached = strings.TrimSpace(branch), false
+		}
+		entries, err := listWorktreeEntries(repo.MainPath)
+		if err != nil {
+			r.add(&ExecResult{Repo: repo.RepoPath, Path: repo.MainPath, Status: StatusFailed, Cause: err.Error()})
+			continue
+		}
+
+		for _, entry := range append([]*worktreeEntry{mainEntry}, entries...) {
+			if !entry.Prunable && execMatched(entry) {
+				pathsChan <- &ExecResult{Repo: repo.RepoPath, Branch: entry.Branch, Path: entry.Path}
+			}
+		}
+	}
+	close(pathsChan)
+	execWaitGroup.Wait()
+	return nil
+}
+
+// execMatched reports whether worktree passes branches regexps, detached worktrees of tags pass tags regexps
+// and ones of merge requests or unknown refs are always matched as they have no branch
+func execMatched(entry *worktreeEntry) bool {
+	if !entry.Detached {
+		return checkSkipCloneRegexps(&branchesSkipCloneRegexList, entry.Branch)
+	}
+	name, _ := getWorktreeName(entry.Path)
+	if tag, found := strings.CutPrefix(name, "tag "); found {
+		return checkSkipCloneRegexps(&tagsSkipCloneRegexList, tag)
+	}
+	return true
+}
+
+// runExec runs command in result path and records its combined output and exit code
+func runExec(result *ExecResult, command []string) {
+	log.WithFields(logrus.Fields{
+		"cmd":  command,
+		"path": result.Path,
+	}).Debug("exec started")
+
+	if config.DryRun {
+		result.Status = StatusSkipped
+		result.Cause = "dry run"
+		return
+	}
+
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Dir = result.Path
+	cmd.Env = append(os.Environ(),
+		"HEYDEVOPS_REPO="+result.Repo,
+		"HEYDEVOPS_BRANCH="+result.Branch,
+		"HEYDEVOPS_PATH="+result.Path,
+	)
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err := cmd.Run()
+	result.Output = output.String()
+	if err == nil {
+		result.Status = StatusSuccess
+		return
+	}
+
+	result.Status = StatusFailed
+	result.Cause = err.Error()
+	var exitError *exec.ExitError
+	if errors.As(err, &exitError) {
+		result.ExitCode = exitError.ExitCode()
+	} else {
+		result.ExitCode = -1
+	}
+	log.WithFields(logrus.Fields{
+		"cmd":  command,
+		"err":  err,
+		"path": result.Path,
+	}).Debug("exec failed")
+}
+
+// PrintExec writes output of every path followed by exit codes summary table
+func PrintExec(writer io.Writer, results []*ExecResult) {
+	for _, result := range results {
+		if result.Status == StatusSkipped {
+			continue
+		}
+		fmt.Fprintf(writer, "==> %s (exit %d)\n", result.Path, result.ExitCode)
+		if result.Output != "" {
+			fmt.Fprint(writer, result.Output)
+			if !strings.HasSuffix(result.Output, "\n") {
+				fmt.Fprintln(writer)
+			}
+		}
+		fmt.Fprintln(writer)
+	}
+
+	counts := make(map[string]int)
+	tabWriter := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tabWriter, "STATUS\tEXIT\tREPO\tBRANCH\tPATH\tCAUSE")
+	for _, result := range results {
+		counts[result.Status]++
+		fmt.Fprintf(tabWriter, "%s\t%d\t%s\t%s\t%s\t%s\n", result.Status, result.ExitCode, result.Repo, result.Branch, result.Path, result.Cause)
+	}
+	_ = tabWriter.Flush()
+
+	fmt.Fprintf(writer, "\nSucceeded: %d, skipped: %d, failed: %d\n",
+		counts[StatusSuccess], counts[StatusSkipped], counts[StatusFailed])
+}
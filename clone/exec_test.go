@@ -0,0 +1,61 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package clone
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExecDetachedWorktrees(t *testing.T) {
+	chdirTemp(t)
+	initClone(t, "group/project/main")
+	runGit(t, "group/project/main", "tag", "v1.0")
+	runGit(t, "group/project/main", "tag", "nightly")
+	runGit(t, "group/project/main", "branch", "feature")
+	runGit(t, "group/project/main", "worktree", "add", "--quiet", "../feature", "feature")
+	runGit(t, "group/project/main", "worktree", "add", "--quiet", "--detach", "../tag-v1.0", "v1.0")
+	runGit(t, "group/project/main", "worktree", "add", "--quiet", "--detach", "../tag-nightly", "nightly")
+	runGit(t, "group/project/main", "worktree", "add", "--quiet", "--detach", "../"+defaultMergeRequestsPrefix+"7", "HEAD")
+
+	configPtr := &ConfigStruct{Layout: LayoutClones, ExpandBranches: true, CloneThreadsCount: 2, SlugEncoding: SlugStrict}
+	configPtr.Repos.Clone = []string{".*"}
+	configPtr.Branches.Clone = []string{"^main$"}
+	configPtr.Tags.Prefix = "tag-"
+	configPtr.Tags.Clone = []string{`^v\d`}
+	configPtr.MergeRequests.Enabled = true
+	configPtr.MergeRequests.Prefix = defaultMergeRequestsPrefix
+	setTestConfig(t, configPtr)
+	compileRegexps()
+
+	results, err := Exec([]string{"true"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, result := range results {
+		if result.Status != StatusSuccess {
+			t.Errorf("exec in %s: %s %s", result.Path, result.Status, result.Cause)
+		}
+		paths = append(paths, result.Path)
+	}
+	want := []string{"group/project/" + defaultMergeRequestsPrefix + "7", "group/project/main", "group/project/tag-v1.0"}
+	if strings.Join(paths, " ") != strings.Join(want, " ") {
+		t.Errorf("exec paths %v, want %v", paths, want)
+	}
+}
@@ -0,0 +1,200 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package clone
+
+import (
+	"fmt"
+	"path/filepath"
+	re "regexp"
+	"strings"
+)
+
+const (
+	GitBackendBinary = "binary"
+	GitBackendGoGit  = "go-git"
+)
+
+// Git runs git operations, paths are relative to the current directory
+type Git interface {
+	SubmoduleAdd(url string, branch string, path string, options *CloneOptions) error
+	Clone(url string, branch string, path string, options *CloneOptions) error
+	Mirror(url string, path string) error
+	RemoteUpdate(path string) error
+	WorktreeAdd(repoPath string, path string, branch string, options *CloneOptions) error
+	WorktreeAddDetached(repoPath string, path string, ref string, options *CloneOptions) error
+	Fetch(path string) error
+	FetchRef(path string, ref string) error
+	Checkout(path string, branch string) error
+	CheckoutDetached(path string, ref string) error
+	Pull(path string, strategy string) error
+	Stash(path string) error
+}
+
+func newGit(backend string) (Git, error) {
+	switch strings.ToLower(backend) {
+	case "", GitBackendBinary:
+		return &binaryGit{}, nil
+	case GitBackendGoGit:
+		return &goGit{binaryGit: binaryGit{}}, nil
+	default:
+		return nil, fmt.Errorf("unknown git backend %q, supported: %s, %s", backend, GitBackendBinary, GitBackendGoGit)
+	}
+}
+
+// binaryGit runs installed git binary
+type binaryGit struct{}
+
+func (g *binaryGit) SubmoduleAdd(url string, branch string, path string, options *CloneOptions) error {
+	if options.empty() {
+		// Submodules change .gitmodules and index of the superproject
+		gitMutex.Lock()
+		defer gitMutex.Unlock()
+		return runCommand("./", "git", "submodule", "add", "--force", "-b", branch, url, path)
+	}
+
+	// git submodule add can't make partial clones, existing clone is added instead
+	if err := runCommand("./", "git", append(append([]string{"clone", "--no-checkout", "-b", branch}, options.args()...), url, path)...); err != nil {
+		return err
+	}
+	if err := addExistingSubmodule(url, branch, path); err != nil {
+		return err
+	}
+	// Sparse checkout is set after absorbing, so core.worktree goes to per worktree config
+	return checkoutSparse(path, options.Sparse)
+}
+
+func addExistingSubmodule(url string, branch string, path string) error {
+	gitMutex.Lock()
+	defer gitMutex.Unlock()
+	if err := runCommand("./", "git", "submodule", "add", "--force", "-b", branch, url, path); err != nil {
+		return err
+	}
+	return runCommand("./", "git", "submodule", "absorbgitdirs", "--", path)
+}
+
+// removePartialSubmodule undoes submodule add of path which failed midway, so it can be added again.
+// The submodule is named by its path as git submodule add does
+func removePartialSubmodule(path string) error {
+	gitMutex.Lock()
+	defer gitMutex.Unlock()
+
+	// git rm drops the section of .gitmodules too when it is staged
+	if err := runCommand("./", "git", "rm", "--cached", "-f", "-q", "--ignore-unmatch", "--", path); err != nil {
+		return err
+	}
+	section := "submodule." + path
+	for _, file := range []string{".gitmodules", filepath.Join(".git", "config")} {
+		// The section is there only when the attempt failed after adding it
+		if _, err := runOutput("./", "git", "config", "-f", file, "--get-regexp", "^"+re.QuoteMeta(section)+"\\."); err != nil {
+			continue
+		}
+		if err := runCommand("./", "git", "config", "-f", file, "--remove-section", section); err != nil {
+			return err
+		}
+	}
+	if err := runCommand("./", "rm", "-rf", filepath.Join(".git", "modules", path)); err != nil {
+		return err
+	}
+	return runCommand("./", "rm", "-rf", path)
+}
+
+func (g *binaryGit) Clone(url string, branch string, path string, options *CloneOptions) error {
+	if options.empty() {
+		return runCommand("./", "git", "clone", "-b", branch, url, path)
+	}
+	if err := runCommand("./", "git", append(append([]string{"clone", "--no-checkout", "-b", branch}, options.args()...), url, path)...); err != nil {
+		return err
+	}
+	return checkoutSparse(path, options.Sparse)
+}
+
+// checkoutSparse fills working tree of path cloned without checkout, only cone patterns are checked out if any
+func checkoutSparse(path string, patterns []string) error {
+	if len(patterns) > 0 {
+		if err := runCommand(path, "git", append([]string{"sparse-checkout", "set", "--cone"}, patterns...)...); err != nil {
+			return err
+		}
+	}
+	return runCommand(path, "git", "reset", "--hard", "--quiet")
+}
+
+func (g *binaryGit) Mirror(url string, path string) error {
+	return runCommand("./", "git", "clone", "--mirror", url, path)
+}
+
+func (g *binaryGit) RemoteUpdate(path string) error {
+	return runCommand(path, "git", "remote", "update", "--prune")
+}
+
+func (g *binaryGit) WorktreeAdd(repoPath string, path string, branch string, options *CloneOptions) error {
+	if options == nil || len(options.Sparse) == 0 {
+		return runCommand(repoPath, "git", "worktree", "add", relativeTo(repoPath, path), branch)
+	}
+	// Worktrees share objects of the repo, only sparse checkout applies to them
+	if err := runCommand(repoPath, "git", "worktree", "add", "--no-checkout", relativeTo(repoPath, path), branch); err != nil {
+		return err
+	}
+	return checkoutSparse(path, options.Sparse)
+}
+
+func (g *binaryGit) WorktreeAddDetached(repoPath string, path string, ref string, options *CloneOptions) error {
+	if options == nil || len(options.Sparse) == 0 {
+		return runCommand(repoPath, "git", "worktree", "add", "--detach", relativeTo(repoPath, path), ref)
+	}
+	if err := runCommand(repoPath, "git", "worktree", "add", "--detach", "--no-checkout", relativeTo(repoPath, path), ref); err != nil {
+		return err
+	}
+	return checkoutSparse(path, options.Sparse)
+}
+
+func (g *binaryGit) Fetch(path string) error {
+	return runCommand(path, "git", "fetch")
+}
+
+// FetchRef fetches the ref into the same local ref even if it isn't reachable from fetched branches or was moved
+func (g *binaryGit) FetchRef(path string, ref string) error {
+	return runCommand(path, "git", "fetch", "--force", "origin", ref+":"+ref)
+}
+
+func (g *binaryGit) Checkout(path string, branch string) error {
+	return runCommand(path, "git", "checkout", branch)
+}
+
+func (g *binaryGit) CheckoutDetached(path string, ref string) error {
+	return runCommand(path, "git", "checkout", "--detach", ref)
+}
+
+func (g *binaryGit) Pull(path string, strategy string) error {
+	switch strategy {
+	case UpdateRebase:
+		return runCommand(path, "git", "pull", "--rebase")
+	case UpdateFetchOnly:
+		return g.Fetch(path)
+	case UpdateResetHard:
+		if err := g.Fetch(path); err != nil {
+			return err
+		}
+		return runCommand(path, "git", "reset", "--hard", "@{upstream}")
+	default:
+		return runCommand(path, "git", "pull", "--ff-only")
+	}
+}
+
+func (g *binaryGit) Stash(path string) error {
+	return runCommand(path, "git", "stash", "push", "--include-untracked", "-m", "heydevops")
+}
@@ -0,0 +1,231 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package clone
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/sirupsen/logrus"
+)
+
+// goGit uses go-git library, operations go-git doesn't implement (submodule add, linked worktrees)
+// are delegated to git binary
+type goGit struct {
+	binaryGit
+}
+
+// Clone makes shallow clones itself, partial and sparse ones are made by git binary
+func (g *goGit) Clone(url string, branch string, path string, options *CloneOptions) error {
+	if options != nil && (options.Filter != "" || len(options.Sparse) > 0) {
+		return g.binaryGit.Clone(url, branch, path, options)
+	}
+	if skipGoGit("clone", path, url, branch) {
+		return nil
+	}
+	cloneOptions := &git.CloneOptions{
+		URL:           url,
+		Auth:          gitAuth,
+		ReferenceName: plumbing.NewBranchReferenceName(branch),
+	}
+	if options != nil {
+		cloneOptions.Depth = options.Depth
+		cloneOptions.SingleBranch = options.Depth > 0 && !options.AllBranches
+	}
+	_, err := git.PlainClone(path, false, cloneOptions)
+	return wrapGoGitError("clone", path, err)
+}
+
+func (g *goGit) Mirror(url string, path string) error {
+	if skipGoGit("mirror", path, url) {
+		return nil
+	}
+	_, err := git.PlainClone(path, true, &git.CloneOptions{
+		URL:    url,
+		Auth:   gitAuth,
+		Mirror: true,
+	})
+	return wrapGoGitError("mirror", path, err)
+}
+
+func (g *goGit) RemoteUpdate(path string) error {
+	if skipGoGit("remote update", path) {
+		return nil
+	}
+	repository, err := openGoGit(path)
+	if err != nil {
+		return err
+	}
+	err = repository.Fetch(&git.FetchOptions{
+		Auth:     gitAuth,
+		RefSpecs: []gitconfig.RefSpec{"+refs/*:refs/*"},
+		Prune:    true,
+		Force:    true,
+	})
+	if errors.Is(err, git.NoErrAlreadyUpToDate) {
+		err = nil
+	}
+	return wrapGoGitError("remote update", path, err)
+}
+
+func (g *goGit) Fetch(path string) error {
+	if skipGoGit("fetch", path) {
+		return nil
+	}
+	repository, err := openGoGit(path)
+	if err != nil {
+		return err
+	}
+	err = repository.Fetch(&git.FetchOptions{Auth: gitAuth})
+	if errors.Is(err, git.NoErrAlreadyUpToDate) {
+		err = nil
+	}
+	return wrapGoGitError("fetch", path, err)
+}
+
+func (g *goGit) Checkout(path string, branch string) error {
+	if skipGoGit("checkout", path, branch) {
+		return nil
+	}
+	repository, err := openGoGit(path)
+	if err != nil {
+		return err
+	}
+	worktree, err := repository.Worktree()
+	if err != nil {
+		return wrapGoGitError("checkout", path, err)
+	}
+
+	checkoutOptions := &git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName(branch)}
+
+	// Create local branch tracking the remote one like git checkout does
+	if _, err := repository.Reference(checkoutOptions.Branch, false); errors.Is(err, plumbing.ErrReferenceNotFound) {
+		remoteReference, err := repository.Reference(plumbing.NewRemoteReferenceName("origin", branch), true)
+		if err != nil {
+			return wrapGoGitError("checkout", path, err)
+		}
+		checkoutOptions.Create = true
+		checkoutOptions.Hash = remoteReference.Hash()
+
+		// Upstream is needed by git binary pulling the branch with rebase strategy
+		err = repository.CreateBranch(&gitconfig.Branch{Name: branch, Remote: "origin", Merge: checkoutOptions.Branch})
+		if err != nil && !errors.Is(err, git.ErrBranchExists) {
+			return wrapGoGitError("checkout", path, err)
+		}
+	}
+
+	return wrapGoGitError("checkout", path, worktree.Checkout(checkoutOptions))
+}
+
+// Pull fast-forwards only like go-git does, rebase isn't supported by go-git and runs git binary
+func (g *goGit) Pull(path string, strategy string) error {
+	switch strategy {
+	case UpdateRebase:
+		return g.binaryGit.Pull(path, strategy)
+	case UpdateFetchOnly:
+		return g.Fetch(path)
+	case UpdateResetHard:
+		if err := g.Fetch(path); err != nil {
+			return err
+		}
+		return g.resetHard(path)
+	}
+
+	if skipGoGit("pull", path, strategy) {
+		return nil
+	}
+	repository, err := openGoGit(path)
+	if err != nil {
+		return err
+	}
+	worktree, err := repository.Worktree()
+	if err != nil {
+		return wrapGoGitError("pull", path, err)
+	}
+	head, err := repository.Head()
+	if err != nil {
+		return wrapGoGitError("pull", path, err)
+	}
+
+	err = worktree.Pull(&git.PullOptions{
+		RemoteName:    "origin",
+		ReferenceName: head.Name(),
+		Auth:          gitAuth,
+	})
+	if errors.Is(err, git.NoErrAlreadyUpToDate) {
+		err = nil
+	}
+	return wrapGoGitError("pull", path, err)
+}
+
+// resetHard resets current branch to its remote branch
+func (g *goGit) resetHard(path string) error {
+	if skipGoGit("reset", path, "--hard") {
+		return nil
+	}
+	repository, err := openGoGit(path)
+	if err != nil {
+		return err
+	}
+	worktree, err := repository.Worktree()
+	if err != nil {
+		return wrapGoGitError("reset", path, err)
+	}
+	head, err := repository.Head()
+	if err != nil {
+		return wrapGoGitError("reset", path, err)
+	}
+	remoteReference, err := repository.Reference(plumbing.NewRemoteReferenceName("origin", head.Name().Short()), true)
+	if err != nil {
+		return wrapGoGitError("reset", path, err)
+	}
+	return wrapGoGitError("reset", path, worktree.Reset(&git.ResetOptions{
+		Commit: remoteReference.Hash(),
+		Mode:   git.HardReset,
+	}))
+}
+
+func openGoGit(path string) (*git.Repository, error) {
+	repository, err := git.PlainOpenWithOptions(path, &git.PlainOpenOptions{EnableDotGitCommonDir: true})
+	return repository, wrapGoGitError("open", path, err)
+}
+
+// skipGoGit logs operation like runCommand does and reports whether it is a dry run
+func skipGoGit(operation string, path string, args ...string) bool {
+	log.WithFields(logrus.Fields{
+		"args": args,
+		"op":   operation,
+		"path": path,
+	}).Trace("go-git: start")
+	return config.DryRun
+}
+
+func wrapGoGitError(operation string, path string, err error) error {
+	if err == nil {
+		return nil
+	}
+	log.WithFields(logrus.Fields{
+		"err":  err,
+		"op":   operation,
+		"path": path,
+	}).Error("go-git: returned error")
+	return fmt.Errorf("go-git %s %s: %w", operation, path, err)
+}
@@ -0,0 +1,138 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package clone
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// initUpstream makes bare repository at path with main and dev branches pushed from author clone
+func initUpstream(t *testing.T, path string) string {
+	t.Helper()
+	initClone(t, "author")
+	runGit(t, "author", "branch", "dev")
+	runGit(t, ".", "clone", "--quiet", "--bare", "author", path)
+	runGit(t, "author", "remote", "add", "origin", "../"+path)
+	upstream, err := filepath.Abs(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return upstream
+}
+
+// pushCommit pushes new commit of the branch to upstream and returns its hash
+func pushCommit(t *testing.T, branch string) string {
+	t.Helper()
+	runGit(t, "author", "checkout", "--quiet", branch)
+	runGit(t, "author", "commit", "--quiet", "--allow-empty", "-m", "upstream "+branch)
+	runGit(t, "author", "push", "--quiet", "origin", branch)
+	return headCommit("author")
+}
+
+func TestGoGitClone(t *testing.T) {
+	setTestConfig(t, &ConfigStruct{})
+	chdirTemp(t)
+	upstream := initUpstream(t, "upstream.git")
+
+	git := &goGit{}
+	if err := git.Clone(upstream, "main", "group/tool", nil); err != nil {
+		t.Fatal(err)
+	}
+	if head, want := headCommit("group/tool"), headCommit("upstream.git"); head != want {
+		t.Errorf("cloned head %s, want %s", head, want)
+	}
+
+	// Local branch is created from the remote one
+	if err := git.Checkout("group/tool", "dev"); err != nil {
+		t.Fatal(err)
+	}
+	branch, err := runOutput("group/tool", "git", "rev-parse", "--abbrev-ref", "HEAD@{upstream}")
+	if err != nil || branch != "origin/dev\n" {
+		t.Errorf("checked out branch tracks %q, want origin/dev: %v", branch, err)
+	}
+	// Rebase runs git binary, it pulls the upstream of the branch
+	upstreamHead := pushCommit(t, "dev")
+	if err := git.Pull("group/tool", UpdateRebase); err != nil {
+		t.Fatal(err)
+	}
+	if head := headCommit("group/tool"); head != upstreamHead {
+		t.Errorf("rebased head %s, want %s", head, upstreamHead)
+	}
+
+	if err := git.Clone(upstream, "main", "group/tool", nil); err == nil {
+		t.Error("clone into existing repo succeeded")
+	}
+}
+
+func TestGoGitWorktree(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		// diverged adds local commit to the worktree
+		diverged bool
+		upstream bool
+	}{
+		{"fast-forward", UpdateFFOnly, false, true},
+		{"fast-forward diverged", UpdateFFOnly, true, false},
+		{"fetch-only", UpdateFetchOnly, false, false},
+		{"reset-hard diverged", UpdateResetHard, true, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setTestConfig(t, &ConfigStruct{})
+			chdirTemp(t)
+			upstream := initUpstream(t, "upstream.git")
+
+			git := &goGit{}
+			if err := git.Clone(upstream, "main", "tool/main", nil); err != nil {
+				t.Fatal(err)
+			}
+			if err := git.WorktreeAdd("tool/main", "tool/dev", "dev", nil); err != nil {
+				t.Fatal(err)
+			}
+			if info, err := os.Stat("tool/dev/.git"); err != nil || info.IsDir() {
+				t.Fatalf("tool/dev isn't linked worktree: %v", err)
+			}
+			if test.diverged {
+				runGit(t, "tool/dev", "commit", "--quiet", "--allow-empty", "-m", "local")
+			}
+			headBefore := headCommit("tool/dev")
+			upstreamHead := pushCommit(t, "dev")
+
+			err := git.Pull("tool/dev", test.strategy)
+			if test.diverged && !test.upstream {
+				if err == nil {
+					t.Error("diverged branch was fast-forwarded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			head := headCommit("tool/dev")
+			if (head == upstreamHead) != test.upstream || (!test.upstream && head != headBefore) {
+				t.Errorf("head %s, upstream %s, before %s, want upstream %v", head, upstreamHead, headBefore, test.upstream)
+			}
+			if fetched, err := runOutput("tool/dev", "git", "rev-parse", "origin/dev"); err != nil || fetched != upstreamHead+"\n" {
+				t.Errorf("origin/dev %q, want %s fetched: %v", fetched, upstreamHead, err)
+			}
+		})
+	}
+}
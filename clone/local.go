@@ -0,0 +1,174 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package clone
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// localRepo is a repo found in the local layout, MainPath is its submodule or clone path
+type localRepo struct {
+	RepoPath string
+	MainPath string
+}
+
+type worktreeEntry struct {
+	Path     string
+	Branch   string
+	Detached bool
+	Prunable bool
+}
+
+// walkSources runs walk in the current directory or, when sources are configured, in subdir of every selected
+// source with its settings like cloneSources syncs them, walk gets the subdir to prefix paths it reports with
+func walkSources(walk func(subdir string) error) error {
+	if len(config.Sources) == 0 {
+		return walk("")
+	}
+
+	baseConfig := config
+	defer func() {
+		config = baseConfig
+		compileRegexps()
+	}()
+
+	workDir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	for _, source := range selectedSources() {
+		config = sourceConfig(baseConfig, source)
+		compileRegexps()
+
+		if source.Subdir != "" && !pathExists(source.Subdir) {
+			log.WithFields(logrus.Fields{
+				"source": source.Name,
+				"subdir": source.Subdir,
+			}).Debug("source isn't synced yet")
+			continue
+		}
+		if err := os.Chdir(filepath.Join(".", source.Subdir)); err != nil {
+			return fmt.Errorf("source %s: %w", source.Name, err)
+		}
+		err := walk(source.Subdir)
+		if err := os.Chdir(workDir); err != nil {
+			log.Fatal("Can't return to working directory: ", err)
+		}
+		if err != nil {
+			return fmt.Errorf("source %s: %w", source.Name, err)
+		}
+	}
+	return nil
+}
+
+// findLocalRepos returns repos of the configured layout found in the current directory
+func findLocalRepos() ([]*localRepo, error) {
+	var mainPaths []string
+
+	switch config.Layout {
+	case LayoutMirror:
+		return nil, fmt.Errorf("%s layout has no working trees", LayoutMirror)
+	case LayoutClones:
+		clonePaths, err := findClones("./")
+		if err != nil {
+			return nil, err
+		}
+		mainPaths = clonePaths
+	default:
+		for _, submodule := range listSubmodules() {
+			mainPaths = append(mainPaths, submodule.path)
+		}
+	}
+
+	var repos []*localRepo
+	for _, mainPath := range mainPaths {
+		repos = append(repos, &localRepo{RepoPath: mainRepoPath(mainPath), MainPath: mainPath})
+	}
+	return repos, nil
+}
+
+// listWorktreeEntries returns linked worktrees of repo at path relative to current directory,
+// the main worktree is not included
+func listWorktreeEntries(path string) ([]*worktreeEntry, error) {
+	if !pathExists(path) {
+		return nil, nil
+	}
+
+	output, err := runOutput(path, "git", "worktree", "list", "--porcelain")
+	if err != nil {
+		return nil, err
+	}
+
+	workDir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	// git prints real paths, the current directory may be reached through a symlink
+	if realWorkDir, err := filepath.EvalSymlinks(workDir); err == nil {
+		workDir = realWorkDir
+	}
+
+	// Entries are separated with empty line, the first one is the main worktree
+	var entries []*worktreeEntry
+	for i, block := range strings.Split(strings.TrimSpace(output), "\n\n") {
+		if i == 0 {
+			continue
+		}
+		entry := &worktreeEntry{}
+		for _, line := range strings.Split(block, "\n") {
+			switch {
+			case strings.HasPrefix(line, "worktree "):
+				entry.Path = strings.TrimPrefix(line, "worktree ")
+				if relPath, err := filepath.Rel(workDir, entry.Path); err == nil {
+					entry.Path = relPath
+				}
+			case strings.HasPrefix(line, "branch "):
+				entry.Branch = strings.TrimPrefix(strings.TrimPrefix(line, "branch "), "refs/heads/")
+			case line == "detached":
+				entry.Detached = true
+			case strings.HasPrefix(line, "prunable"):
+				entry.Prunable = true
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// listWorktrees returns paths of linked worktrees which still exist, stale ones are cleaned
+// with git worktree prune
+func listWorktrees(path string) ([]string, error) {
+	entries, err := listWorktreeEntries(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var worktreePaths []string
+	for _, entry := range entries {
+		if !entry.Prunable {
+			worktreePaths = append(worktreePaths, entry.Path)
+		}
+	}
+	return worktreePaths, nil
+}
@@ -0,0 +1,123 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package clone
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// chdirTemp makes a temporary directory current for the test
+func chdirTemp(t *testing.T) string {
+	t.Helper()
+	workDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.Chdir(workDir)
+	})
+	return dir
+}
+
+// runGit runs git in path relative to the current directory and fails the test on error
+func runGit(t *testing.T, path string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = path
+	cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %s in %s: %v: %s", strings.Join(args, " "), path, err, output)
+	}
+}
+
+// initClone makes a repository with one commit at path like a clone of clones layout
+func initClone(t *testing.T, path string) {
+	t.Helper()
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, path, "init", "--quiet", "--initial-branch=main")
+	runGit(t, path, "commit", "--quiet", "--allow-empty", "-m", "init")
+}
+
+func TestLocalReposOfSources(t *testing.T) {
+	chdirTemp(t)
+	initClone(t, "a/group/one")
+	initClone(t, "b/group/two")
+	initClone(t, "b/other/three")
+
+	configPtr := &ConfigStruct{
+		Layout:            LayoutClones,
+		CloneThreadsCount: 2,
+		Sources: []*SourceStruct{
+			{Name: "a", Subdir: "a"},
+			{Name: "b", Subdir: "b", Repos: &ReposStruct{SkipCloneStringsStruct: SkipCloneStringsStruct{Clone: []string{"^group/"}}}},
+			{Name: "missing", Subdir: "c"},
+		},
+	}
+	configPtr.Repos.Clone = []string{".*"}
+	setTestConfig(t, configPtr)
+
+	statuses, err := Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var statusPaths []string
+	for _, status := range statuses {
+		if status.Error != "" {
+			t.Errorf("status of %s: %s", status.Path, status.Error)
+		}
+		statusPaths = append(statusPaths, status.Path)
+	}
+	want := []string{"a/group/one", "b/group/two", "b/other/three"}
+	if strings.Join(statusPaths, " ") != strings.Join(want, " ") {
+		t.Errorf("status paths %v, want %v", statusPaths, want)
+	}
+
+	// Repos regexps of the source apply to paths relative to its subdir
+	results, err := Exec([]string{"git", "rev-parse", "--show-toplevel"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var execPaths []string
+	for _, result := range results {
+		if result.Status != StatusSuccess {
+			t.Errorf("exec in %s: %s %s", result.Path, result.Status, result.Cause)
+		}
+		if !strings.HasSuffix(strings.TrimSpace(result.Output), result.Path) {
+			t.Errorf("exec in %s ran in %s", result.Path, result.Output)
+		}
+		execPaths = append(execPaths, result.Path)
+	}
+	want = []string{"a/group/one", "b/group/two"}
+	if strings.Join(execPaths, " ") != strings.Join(want, " ") {
+		t.Errorf("exec paths %v, want %v", execPaths, want)
+	}
+
+	if config != configPtr {
+		t.Error("config of the last source is left after walking sources")
+	}
+}
@@ -0,0 +1,118 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package clone
+
+import (
+	"fmt"
+	re "regexp"
+	"strconv"
+
+	"github.com/Logunov/heydevops/provider"
+	"github.com/sirupsen/logrus"
+)
+
+// MergeRequestsStruct enables worktrees of open merge requests and filters them,
+// empty filters aren't applied
+type MergeRequestsStruct struct {
+	Enabled bool
+	Prefix  string
+	// Labels selects merge requests having any of the labels
+	Labels  []string
+	Authors []string
+	// TargetBranches are regexps of target branch names
+	TargetBranches []string
+	Draft          *bool
+}
+
+// defaultMergeRequestsPrefix keeps merge request paths apart from branch paths
+const defaultMergeRequestsPrefix = "_mr-"
+
+var mergeRequestsTargetRegexList []*re.Regexp
+
+func mergeRequestsEnabled() bool {
+	return config.ExpandBranches && config.MergeRequests.Enabled
+}
+
+// getMergeRequestPath returns path the merge request is checked out to
+func getMergeRequestPath(repoPath string, mergeRequest *provider.MergeRequest) string {
+	path, _ := worktreePath(repoPath, mergeRequest.SourceBranch, config.MergeRequests.Prefix, strconv.Itoa(mergeRequest.IID), "")
+	return path
+}
+
+// addMergeRequests checks source branches of matched merge requests out as detached worktrees
+// of the default branch clone, returns true when all of them were synced successfully
+func addMergeRequests(repoPath string, defaultBranch string, mergeRequests []*provider.MergeRequest, projectPlan *PlanProject) bool {
+	mainPath := getBranchPath(repoPath, defaultBranch)
+	cloneOptions := getCloneOptions(repoPath)
+
+	synced := true
+	for _, mergeRequest := range mergeRequests {
+		mergeRequestPath := getMergeRequestPath(repoPath, mergeRequest)
+		mergeRequestPlan := &PlanBranch{Name: mergeRequest.SourceBranch, Path: mergeRequestPath, Matched: true}
+		projectPlan.addMergeRequest(mergeRequestPlan)
+
+		result := fmt.Sprintf("mr %d", mergeRequest.IID)
+		if reason := matchMergeRequest(mergeRequest); reason != "" {
+			mergeRequestPlan.SkippedBy, mergeRequestPlan.Matched = reason, false
+			report.skip(repoPath, result, mergeRequestPath, reason)
+			continue
+		}
+
+		log.WithFields(logrus.Fields{
+			"mergeRequest": mergeRequest.IID,
+			"path":         mergeRequestPath,
+			"repoPath":     repoPath,
+			"sourceBranch": mergeRequest.SourceBranch,
+		}).Debug("merge request checkout started")
+
+		outcome, err := syncDetached(newPlanGit(&mergeRequestPlan.Actions), mainPath, mergeRequest.Ref, mergeRequest.CommitID, mergeRequestPath, cloneOptions)
+		if !reportDetached(repoPath, result, mergeRequestPlan, outcome, err) {
+			synced = false
+		}
+	}
+	return synced
+}
+
+// matchMergeRequest returns the reason merge request doesn't match merge requests filters,
+// it is empty when merge request matches
+func matchMergeRequest(mergeRequest *provider.MergeRequest) string {
+	filters := &config.MergeRequests
+
+	if len(filters.Labels) > 0 && !containsAnyFold(mergeRequest.Labels, filters.Labels) {
+		return "has none of labels"
+	}
+	if len(filters.Authors) > 0 && !containsFold(filters.Authors, mergeRequest.Author) {
+		return "author is " + mergeRequest.Author
+	}
+	if len(mergeRequestsTargetRegexList) > 0 && !matchAnyRegexp(mergeRequestsTargetRegexList, mergeRequest.TargetBranch) {
+		return "target branch is " + mergeRequest.TargetBranch
+	}
+	if filters.Draft != nil && *filters.Draft != mergeRequest.Draft {
+		return fmt.Sprintf("draft is %t", mergeRequest.Draft)
+	}
+	return ""
+}
+
+func matchAnyRegexp(regexps []*re.Regexp, str string) bool {
+	for _, regexp := range regexps {
+		if regexp.MatchString(str) {
+			return true
+		}
+	}
+	return false
+}
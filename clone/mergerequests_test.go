@@ -0,0 +1,149 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package clone
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/Logunov/heydevops/helpers"
+	"github.com/Logunov/heydevops/provider"
+)
+
+// setMergeRequestsTest sets merge requests filters up, target branch regexps are compiled
+func setMergeRequestsTest(t *testing.T, configPtr *ConfigStruct) {
+	t.Helper()
+	savedTargets := mergeRequestsTargetRegexList
+	t.Cleanup(func() {
+		mergeRequestsTargetRegexList = savedTargets
+	})
+
+	setTestConfig(t, configPtr)
+	mergeRequestsTargetRegexList = compileSkipCloneRegexps(configPtr.MergeRequests.TargetBranches)
+}
+
+func TestMatchMergeRequest(t *testing.T) {
+	yes, no := true, false
+	mergeRequest := &provider.MergeRequest{
+		IID:          7,
+		SourceBranch: "feature/login",
+		TargetBranch: "release/1.0",
+		Author:       "Alice",
+		Labels:       []string{"Backend", "security"},
+	}
+
+	tests := []struct {
+		name    string
+		filters MergeRequestsStruct
+		reason  string
+	}{
+		{"no filters", MergeRequestsStruct{}, ""},
+		{"label case insensitive", MergeRequestsStruct{Labels: []string{"backend"}}, ""},
+		{"no label", MergeRequestsStruct{Labels: []string{"frontend", "docs"}}, "has none of labels"},
+		{"author case insensitive", MergeRequestsStruct{Authors: []string{"bob", "alice"}}, ""},
+		{"other author", MergeRequestsStruct{Authors: []string{"bob"}}, "author is Alice"},
+		{"target branch", MergeRequestsStruct{TargetBranches: []string{"^main$", "^release/"}}, ""},
+		{"other target branch", MergeRequestsStruct{TargetBranches: []string{"^main$"}}, "target branch is release/1.0"},
+		{"not draft", MergeRequestsStruct{Draft: &no}, ""},
+		{"drafts only", MergeRequestsStruct{Draft: &yes}, "draft is false"},
+		{"first failed filter", MergeRequestsStruct{Labels: []string{"docs"}, Authors: []string{"bob"}}, "has none of labels"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setMergeRequestsTest(t, &ConfigStruct{MergeRequests: test.filters})
+			if reason := matchMergeRequest(mergeRequest); reason != test.reason {
+				t.Errorf("matchMergeRequest() = %q, want %q", reason, test.reason)
+			}
+		})
+	}
+}
+
+func TestAddMergeRequests(t *testing.T) {
+	mergeRequests := []*provider.MergeRequest{
+		{IID: 7, SourceBranch: "feature/login", TargetBranch: "main", Ref: "refs/merge-requests/7/head"},
+		{IID: 12, SourceBranch: "fix", TargetBranch: "release", Ref: "refs/merge-requests/12/head"},
+		{IID: 30, SourceBranch: "feature/login", TargetBranch: "main", Ref: "refs/merge-requests/30/head", Fork: true},
+	}
+
+	tests := []struct {
+		name     string
+		prefix   string
+		template string
+		results  []string
+	}{
+		{"default prefix", defaultMergeRequestsPrefix, "", []string{
+			"success mr 7 group/tool/_mr-7 worktree added (dry run)",
+			"skipped mr 12 group/tool/_mr-12 target branch is release",
+			"success mr 30 group/tool/_mr-30 worktree added (dry run)",
+		}},
+		{"own prefix", "review-", "", []string{
+			"success mr 7 group/tool/review-7 worktree added (dry run)",
+			"skipped mr 12 group/tool/review-12 target branch is release",
+			"success mr 30 group/tool/review-30 worktree added (dry run)",
+		}},
+		{"path template", defaultMergeRequestsPrefix, "{{.Path}}/wt-{{.BranchPrefix}}{{.BranchSlug}}", []string{
+			"success mr 7 group/tool/wt-_mr-7 worktree added (dry run)",
+			"skipped mr 12 group/tool/wt-_mr-12 target branch is release",
+			"success mr 30 group/tool/wt-_mr-30 worktree added (dry run)",
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			configPtr := &ConfigStruct{DryRun: true, ExpandBranches: true, Layout: LayoutClones}
+			configPtr.MergeRequests = MergeRequestsStruct{Enabled: true, Prefix: test.prefix, TargetBranches: []string{"^main$"}}
+			setMergeRequestsTest(t, configPtr)
+			setPathTemplate(t, test.template)
+			chdirTemp(t)
+			savedReport, savedState, savedBackend, savedRetry := report, state, gitBackend, gitRetry
+			t.Cleanup(func() {
+				report, state, gitBackend, gitRetry = savedReport, savedState, savedBackend, savedRetry
+			})
+			SetLogger(log)
+			report, state = newReport(), &State{Projects: make(map[string]*ProjectState)}
+			gitBackend, gitRetry = &binaryGit{}, &RetryConfig{Attempts: 1}
+
+			project := &provider.Project{Namespace: "group", Name: "tool", DefaultBranch: "main"}
+			repoPath, fields, err := getRepoPath(project, "group/tool")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := claimRepoPath(repoPath, fields); err != nil {
+				t.Fatal(err)
+			}
+
+			projectPlan := &PlanProject{Path: repoPath}
+			if !addMergeRequests(repoPath, "main", mergeRequests, projectPlan) {
+				t.Error("merge requests weren't synced")
+			}
+
+			var results []string
+			for _, result := range report.Results {
+				results = append(results, strings.Join([]string{result.Status, result.Branch, result.Path, result.Cause}, " "))
+			}
+			if strings.Join(results, "\n") != strings.Join(test.results, "\n") {
+				t.Errorf("results are\n%s\nwant\n%s", strings.Join(results, "\n"), strings.Join(test.results, "\n"))
+			}
+			// Merge requests are named by source branches in the plan, fork ones too
+			for i, plan := range projectPlan.MergeRequests {
+				if plan.Name != mergeRequests[i].SourceBranch {
+					t.Errorf("merge request plan %d is named %s, want %s", i, plan.Name, mergeRequests[i].SourceBranch)
+				}
+			}
+		})
+	}
+}
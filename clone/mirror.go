@@ -0,0 +1,88 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package clone
+
+import (
+	"strings"
+
+	"github.com/Logunov/heydevops/provider"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	mirrorSuffix     = ".git"
+	wikiMirrorSuffix = ".wiki.git"
+)
+
+// addMirrorRepo mirrors the project (and its wiki if configured) into bare repository,
+// returns true when the project was mirrored successfully
+func addMirrorRepo(repoPath string, cloneURL string, projectPtr *provider.Project, projectPlan *PlanProject) bool {
+	git := newPlanGit(&projectPlan.Actions)
+
+	mirrorPath := repoPath + mirrorSuffix
+	outcome, err := syncMirror(git, cloneURL, mirrorPath)
+	if err != nil {
+		report.fail(repoPath, "", mirrorPath, err)
+		return false
+	}
+	state.rememberPath(repoPath, mirrorPath)
+	if note := retriesNote(projectPlan.Actions); note != "" {
+		outcome += ", " + note
+	}
+	report.success(repoPath, "", mirrorPath, outcome)
+
+	if config.MirrorWikis && projectPtr.WikiEnabled {
+		wikiPath := repoPath + wikiMirrorSuffix
+		wikiActions := len(projectPlan.Actions)
+		// Wiki repository doesn't exist until the first page is created
+		outcome, err := syncMirror(git, getWikiURL(cloneURL), wikiPath)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"err":  err,
+				"repo": repoPath,
+			}).Warn("wiki mirror failed")
+			report.skip(repoPath, "wiki", wikiPath, "wiki not mirrored: "+err.Error())
+		} else {
+			if note := retriesNote(projectPlan.Actions[wikiActions:]); note != "" {
+				outcome += ", " + note
+			}
+			report.success(repoPath, "wiki", wikiPath, outcome)
+		}
+	}
+	return true
+}
+
+// syncMirror mirrors the repository or updates existing mirror, returns what was done
+func syncMirror(git Git, url string, mirrorPath string) (string, error) {
+	if !pathExists(mirrorPath) {
+		return dryRunOutcome("mirrored"), git.Mirror(url, mirrorPath)
+	}
+	return dryRunOutcome("updated"), git.RemoteUpdate(mirrorPath)
+}
+
+func getWikiURL(cloneURL string) string {
+	return strings.TrimSuffix(cloneURL, mirrorSuffix) + wikiMirrorSuffix
+}
+
+// mirrorRepoPath returns repo path of the mirror, wiki mirrors belong to the same repo
+func mirrorRepoPath(mirrorPath string) string {
+	if strings.HasSuffix(mirrorPath, wikiMirrorSuffix) {
+		return strings.TrimSuffix(mirrorPath, wikiMirrorSuffix)
+	}
+	return strings.TrimSuffix(mirrorPath, mirrorSuffix)
+}
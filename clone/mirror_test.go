@@ -0,0 +1,61 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package clone
+
+import (
+	"testing"
+
+	. "github.com/Logunov/heydevops/helpers"
+	"github.com/Logunov/heydevops/provider"
+)
+
+func TestAddMirrorRepo(t *testing.T) {
+	chdirTemp(t)
+	upstream := initUpstream(t, "upstream.git")
+	savedReport, savedState, savedBackend, savedRetry := report, state, gitBackend, gitRetry
+	t.Cleanup(func() {
+		report, state, gitBackend, gitRetry = savedReport, savedState, savedBackend, savedRetry
+	})
+	SetLogger(log)
+	state = &State{Projects: make(map[string]*ProjectState)}
+	gitBackend, gitRetry = &binaryGit{}, &RetryConfig{Attempts: 1}
+
+	tests := []struct {
+		name    string
+		dryRun  bool
+		outcome string
+	}{
+		{"new mirror in dry run", true, "mirrored (dry run)"},
+		{"new mirror", false, "mirrored"},
+		{"mirror update", false, "updated"},
+		{"mirror update in dry run", true, "updated (dry run)"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setTestConfig(t, &ConfigStruct{DryRun: test.dryRun, Layout: LayoutMirror})
+			report = newReport()
+
+			if !addMirrorRepo("group/tool", upstream, &provider.Project{}, &PlanProject{Path: "group/tool"}) {
+				t.Fatalf("mirror failed: %+v", report.Results)
+			}
+			if len(report.Results) != 1 || report.Results[0].Cause != test.outcome {
+				t.Errorf("results %+v, want one with cause %q", report.Results, test.outcome)
+			}
+		})
+	}
+}
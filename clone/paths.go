@@ -0,0 +1,218 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package clone
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/Logunov/heydevops/provider"
+)
+
+// DefaultPathTemplate keeps projects at their web URL paths with worktrees of branches inside
+const DefaultPathTemplate = "{{.Path}}/{{.BranchPrefix}}{{.BranchSlug}}{{.BranchSuffix}}"
+
+// emptyRepoBranch stands for the default branch of empty repos when their directory is rendered
+const emptyRepoBranch = "HEAD"
+
+// PathFields are fields of path template, branch ones are empty when branches aren't expanded
+type PathFields struct {
+	// Path is the project web URL with gitlab-url and root-remove stripped
+	Path      string
+	Namespace string
+	Name      string
+	// Branch is the name of the branch, tag or merge request source branch
+	Branch       string
+	BranchPrefix string
+	BranchSlug   string
+	BranchSuffix string
+}
+
+var (
+	pathTemplate *template.Template
+	// repoFields are fields of synced projects by their repo paths, worktree paths are rendered with them
+	repoFields      map[string]*PathFields
+	repoFieldsMutex sync.Mutex
+)
+
+func parsePathTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		text = DefaultPathTemplate
+	}
+	return template.New("path-template").Parse(text)
+}
+
+func resetRepoPaths() {
+	repoFieldsMutex.Lock()
+	defer repoFieldsMutex.Unlock()
+	repoFields = make(map[string]*PathFields)
+}
+
+func (f PathFields) withBranch(branch string, prefix string, slug string, suffix string) *PathFields {
+	f.Branch, f.BranchPrefix, f.BranchSlug, f.BranchSuffix = branch, prefix, slug, suffix
+	return &f
+}
+
+// renderPath renders the template into a clean slash separated path inside the current directory
+func renderPath(tmpl *template.Template, fields *PathFields) (string, error) {
+	var builder strings.Builder
+	if err := tmpl.Execute(&builder, fields); err != nil {
+		return "", err
+	}
+	path := filepath.ToSlash(filepath.Clean(builder.String()))
+	if path == "." || !filepath.IsLocal(path) {
+		return "", fmt.Errorf("path template renders %q, it must be a path inside the current directory", builder.String())
+	}
+	return path, nil
+}
+
+// getRepoPath renders local path of the project, with expanded branches it is the directory of worktrees
+func getRepoPath(projectPtr *provider.Project, projectPath string) (string, *PathFields, error) {
+	fields := &PathFields{Path: projectPath, Namespace: projectPtr.Namespace, Name: projectPtr.Name}
+	if !config.ExpandBranches {
+		repoPath, err := renderPath(pathTemplate, fields)
+		return repoPath, fields, err
+	}
+
+	branch := projectPtr.DefaultBranch
+	if branch == "" {
+		branch = emptyRepoBranch
+	}
+	mainPath, err := renderPath(pathTemplate, fields.withBranch(branch, config.Branches.Prefix, getBranchSlug(branch), config.Branches.Suffix))
+	if err != nil {
+		return "", nil, err
+	}
+	repoPath := filepath.ToSlash(filepath.Dir(mainPath))
+	if repoPath == "." {
+		return "", nil, fmt.Errorf("path template renders %s, branch worktrees must be inside repo directory", mainPath)
+	}
+	return repoPath, fields, nil
+}
+
+// claimRepoPath remembers fields of the project synced into repo path,
+// it fails if another project of the run renders the same path
+func claimRepoPath(repoPath string, fields *PathFields) error {
+	repoFieldsMutex.Lock()
+	defer repoFieldsMutex.Unlock()
+	if other, ok := repoFields[repoPath]; ok && other.Path != fields.Path {
+		return fmt.Errorf("path %s is taken by project %s, path template must render distinct paths", repoPath, other.Path)
+	}
+	repoFields[repoPath] = fields
+	return nil
+}
+
+// worktreePath renders path of the branch, tag or merge request worktree inside repo directory,
+// repos which weren't claimed keep the default layout
+func worktreePath(repoPath string, branch string, prefix string, slug string, suffix string) (string, error) {
+	defaultPath := repoPath + "/" + prefix + slug + suffix
+
+	repoFieldsMutex.Lock()
+	fields, ok := repoFields[repoPath]
+	repoFieldsMutex.Unlock()
+	if !ok {
+		return defaultPath, nil
+	}
+
+	path, err := renderPath(pathTemplate, fields.withBranch(branch, prefix, slug, suffix))
+	if err != nil {
+		return defaultPath, err
+	}
+	if filepath.ToSlash(filepath.Dir(path)) != repoPath {
+		return defaultPath, fmt.Errorf("path template renders %s out of repo directory %s", path, repoPath)
+	}
+	return path, nil
+}
+
+// getBranchPath returns path the branch is cloned to, it is repo path when branches aren't expanded,
+// template errors are reported by checkWorktreePaths before anything is synced
+func getBranchPath(repoPath string, branch string) string {
+	if !config.ExpandBranches {
+		return repoPath
+	}
+	path, _ := worktreePath(repoPath, branch, config.Branches.Prefix, getBranchSlug(branch), config.Branches.Suffix)
+	return path
+}
+
+// checkWorktreePaths renders paths of the default branch and selected branches, tags and merge requests
+// before anything is synced, it fails if a path can't be rendered or two of them are the same.
+// Paths differing in case only are the same on case-insensitive file systems
+func checkWorktreePaths(repoPath string, defaultBranch string, branches []*provider.Branch, skipReasons map[string]string,
+	tags []*provider.Tag, mergeRequests []*provider.MergeRequest) error {
+	type owner struct {
+		result string
+		path   string
+	}
+	owners := make(map[string]*owner)
+	check := func(result string, path string, err error) error {
+		if err != nil {
+			return fmt.Errorf("%s: %w", result, err)
+		}
+		other, ok := owners[strings.ToLower(path)]
+		switch {
+		case ok && other.path == path:
+			return fmt.Errorf("%s and %s have the same path %s, change slash, prefixes, path template or use strict slug encoding", other.result, result, path)
+		case ok:
+			return fmt.Errorf("%s and %s have paths %s and %s differing in case only, they clash on case-insensitive file systems", other.result, result, other.path, path)
+		}
+		owners[strings.ToLower(path)] = &owner{result: result, path: path}
+		return nil
+	}
+
+	path, err := worktreePath(repoPath, defaultBranch, config.Branches.Prefix, getBranchSlug(defaultBranch), config.Branches.Suffix)
+	if err := check(defaultBranch, path, err); err != nil {
+		return err
+	}
+	for _, branch := range branches {
+		if _, skipped := skipReasons[branch.Name]; skipped || branch.Default || !checkSkipCloneRegexps(&branchesSkipCloneRegexList, branch.Name) {
+			continue
+		}
+		path, err := worktreePath(repoPath, branch.Name, config.Branches.Prefix, getBranchSlug(branch.Name), config.Branches.Suffix)
+		if err := check(branch.Name, path, err); err != nil {
+			return err
+		}
+	}
+
+	tagReasons := selectTags(tags)
+	for _, tag := range tags {
+		if _, skipped := tagReasons[tag.Name]; skipped || !checkSkipCloneRegexps(&tagsSkipCloneRegexList, tag.Name) {
+			continue
+		}
+		path, err := worktreePath(repoPath, tag.Name, config.Tags.Prefix, getTagSlug(tag.Name), config.Tags.Suffix)
+		if err := check("tag "+tag.Name, path, err); err != nil {
+			return err
+		}
+	}
+
+	if !mergeRequestsEnabled() {
+		return nil
+	}
+	for _, mergeRequest := range mergeRequests {
+		if matchMergeRequest(mergeRequest) != "" {
+			continue
+		}
+		path, err := worktreePath(repoPath, mergeRequest.SourceBranch, config.MergeRequests.Prefix, strconv.Itoa(mergeRequest.IID), "")
+		if err := check(fmt.Sprintf("mr %d", mergeRequest.IID), path, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
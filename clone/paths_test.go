@@ -0,0 +1,113 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package clone
+
+import (
+	re "regexp"
+	"strings"
+	"testing"
+
+	"github.com/Logunov/heydevops/provider"
+)
+
+// setPathTemplate parses path template for the test and forgets claimed repo paths
+func setPathTemplate(t *testing.T, text string) {
+	t.Helper()
+	savedTemplate, savedFields := pathTemplate, repoFields
+	t.Cleanup(func() {
+		pathTemplate, repoFields = savedTemplate, savedFields
+	})
+
+	var err error
+	if pathTemplate, err = parsePathTemplate(text); err != nil {
+		t.Fatal(err)
+	}
+	resetRepoPaths()
+}
+
+func TestClaimRepoPath(t *testing.T) {
+	setTestConfig(t, &ConfigStruct{})
+	setPathTemplate(t, "{{.Name}}")
+
+	tests := []struct {
+		project *provider.Project
+		path    string
+		err     string
+	}{
+		{&provider.Project{Namespace: "group", Name: "tool"}, "group/tool", ""},
+		// The same project synced again keeps its path
+		{&provider.Project{Namespace: "group", Name: "tool"}, "group/tool", ""},
+		{&provider.Project{Namespace: "other", Name: "tool"}, "other/tool", "path tool is taken by project group/tool"},
+		{&provider.Project{Namespace: "other", Name: "lib"}, "other/lib", ""},
+	}
+	for _, test := range tests {
+		repoPath, fields, err := getRepoPath(test.project, test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = claimRepoPath(repoPath, fields)
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("claimRepoPath(%s) of %s: %v", repoPath, test.path, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("claimRepoPath(%s) of %s = %v, want %q", repoPath, test.path, err, test.err)
+		}
+	}
+}
+
+func TestCheckWorktreePaths(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		branches []string
+		err      string
+	}{
+		{"distinct", "", []string{"main", "feature/a", "feature-a"}, ""},
+		{"same path", "{{.Path}}/{{.BranchPrefix}}{{.Branch | len}}", []string{"main", "test"}, "have the same path group/tool/4"},
+		{"case only", "", []string{"main", "Feature", "feature"}, "differing in case only"},
+		{"out of repo directory", "{{.Path}}/{{.Branch}}", []string{"main", "feature/a"}, "out of repo directory"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setTestConfig(t, &ConfigStruct{ExpandBranches: true, SlugEncoding: SlugStrict, Branches: BranchesStruct{Slash: "-"}})
+			branchesSkipCloneRegexList = SkipCloneRegexStruct{Clone: []*re.Regexp{re.MustCompile(".*")}}
+			setPathTemplate(t, test.template)
+
+			project := &provider.Project{Namespace: "group", Name: "tool", DefaultBranch: "main"}
+			repoPath, fields, err := getRepoPath(project, "group/tool")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := claimRepoPath(repoPath, fields); err != nil {
+				t.Fatal(err)
+			}
+
+			var branches []*provider.Branch
+			for _, name := range test.branches {
+				branches = append(branches, &provider.Branch{Name: name, Default: name == project.DefaultBranch})
+			}
+			err = checkWorktreePaths(repoPath, project.DefaultBranch, branches, nil, nil, nil)
+			switch {
+			case test.err == "" && err != nil:
+				t.Errorf("checkWorktreePaths: %v", err)
+			case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+				t.Errorf("checkWorktreePaths = %v, want %q", err, test.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package clone
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.yaml.in/yaml/v3"
+)
+
+const (
+	OutputJSON = "json"
+	OutputYAML = "yaml"
+)
+
+// Plan describes every discovered project and branch and git actions run (or would run in dry run) for them
+type Plan struct {
+	mutex    sync.Mutex
+	DryRun   bool           `json:"dry_run" yaml:"dry_run"`
+	Projects []*PlanProject `json:"projects" yaml:"projects"`
+}
+
+type PlanProject struct {
+	// Source is a name of the source, paths of its projects are relative to its subdir
+	Source    string        `json:"source,omitempty" yaml:"source,omitempty"`
+	Path      string        `json:"path" yaml:"path"`
+	WebURL    string        `json:"web_url" yaml:"web_url"`
+	CloneURL  string        `json:"clone_url,omitempty" yaml:"clone_url,omitempty"`
+	Matched   bool          `json:"matched" yaml:"matched"`
+	MatchedBy string        `json:"matched_by,omitempty" yaml:"matched_by,omitempty"`
+	SkippedBy string        `json:"skipped_by,omitempty" yaml:"skipped_by,omitempty"`
+	Branches  []*PlanBranch `json:"branches,omitempty" yaml:"branches,omitempty"`
+	Tags      []*PlanBranch `json:"tags,omitempty" yaml:"tags,omitempty"`
+	// MergeRequests are named by source branches
+	MergeRequests []*PlanBranch `json:"merge_requests,omitempty" yaml:"merge_requests,omitempty"`
+	Actions       []*PlanAction `json:"actions,omitempty" yaml:"actions,omitempty"`
+	mutex         sync.Mutex
+}
+
+type PlanBranch struct {
+	Name      string        `json:"name" yaml:"name"`
+	Slug      string        `json:"slug,omitempty" yaml:"slug,omitempty"`
+	Path      string        `json:"path" yaml:"path"`
+	Default   bool          `json:"default" yaml:"default"`
+	Matched   bool          `json:"matched" yaml:"matched"`
+	MatchedBy string        `json:"matched_by,omitempty" yaml:"matched_by,omitempty"`
+	SkippedBy string        `json:"skipped_by,omitempty" yaml:"skipped_by,omitempty"`
+	Actions   []*PlanAction `json:"actions,omitempty" yaml:"actions,omitempty"`
+}
+
+// PlanAction is a git operation, Path is a directory it runs in
+type PlanAction struct {
+	Operation string   `json:"operation" yaml:"operation"`
+	Path      string   `json:"path" yaml:"path"`
+	Args      []string `json:"args,omitempty" yaml:"args,omitempty"`
+	Attempts  int      `json:"attempts,omitempty" yaml:"attempts,omitempty"`
+}
+
+func newPlan() *Plan {
+	return &Plan{DryRun: config.DryRun}
+}
+
+func (p *Plan) addProject(projectPlan *PlanProject) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.Projects = append(p.Projects, projectPlan)
+}
+
+func (p *PlanProject) addBranch(branchPlan *PlanBranch) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.Branches = append(p.Branches, branchPlan)
+}
+
+func (p *PlanProject) addTag(tagPlan *PlanBranch) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.Tags = append(p.Tags, tagPlan)
+}
+
+func (p *PlanProject) addMergeRequest(mergeRequestPlan *PlanBranch) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.MergeRequests = append(p.MergeRequests, mergeRequestPlan)
+}
+
+// Write encodes the plan in format, projects are sorted by path
+func (p *Plan) Write(writer io.Writer, format string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	sort.Slice(p.Projects, func(i, j int) bool {
+		return p.Projects[i].Path < p.Projects[j].Path
+	})
+
+	return WriteOutput(writer, format, p)
+}
+
+// WriteOutput encodes value in machine-readable format
+func WriteOutput(writer io.Writer, format string, value interface{}) error {
+	switch format {
+	case OutputJSON:
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	case OutputYAML:
+		encoder := yaml.NewEncoder(writer)
+		encoder.SetIndent(2)
+		defer encoder.Close()
+		return encoder.Encode(value)
+	default:
+		return fmt.Errorf("unknown output format %q, supported: %s, %s", format, OutputJSON, OutputYAML)
+	}
+}
+
+// planGit records every operation into actions before running it
+type planGit struct {
+	Git
+	actions *[]*PlanAction
+}
+
+func newPlanGit(actions *[]*PlanAction) Git {
+	return &planGit{Git: gitBackend, actions: actions}
+}
+
+func (g *planGit) record(operation string, path string, args ...string) *PlanAction {
+	action := &PlanAction{Operation: operation, Path: path, Args: args}
+	*g.actions = append(*g.actions, action)
+	return action
+}
+
+// retry runs network operation with retries counting attempts of the action
+func (g *planGit) retry(action *PlanAction, operation func() error) error {
+	return gitRetry.Do(action.Operation+" "+action.Path, retryableGit, func() error {
+		action.Attempts++
+		return operation()
+	})
+}
+
+// retryCreating retries operation creating a new path, what a failed attempt left is removed before the next one
+func (g *planGit) retryCreating(action *PlanAction, removePartial func() error, operation func() error) error {
+	return g.retry(action, func() error {
+		if action.Attempts > 1 {
+			if err := removePartial(); err != nil {
+				return fmt.Errorf("removing what failed attempt left: %w", err)
+			}
+		}
+		return operation()
+	})
+}
+
+func (g *planGit) SubmoduleAdd(url string, branch string, path string, options *CloneOptions) error {
+	action := g.record("submodule add", "./", append([]string{url, branch, path}, options.planArgs()...)...)
+	return g.retryCreating(action, func() error {
+		return removePartialSubmodule(path)
+	}, func() error {
+		return g.Git.SubmoduleAdd(url, branch, path, options)
+	})
+}
+
+func (g *planGit) Clone(url string, branch string, path string, options *CloneOptions) error {
+	action := g.record("clone", "./", append([]string{url, branch, path}, options.planArgs()...)...)
+	return g.retryCreating(action, func() error {
+		return runCommand("./", "rm", "-rf", path)
+	}, func() error {
+		return g.Git.Clone(url, branch, path, options)
+	})
+}
+
+func (g *planGit) Mirror(url string, path string) error {
+	action := g.record("mirror", "./", url, path)
+	return g.retryCreating(action, func() error {
+		return runCommand("./", "rm", "-rf", path)
+	}, func() error {
+		return g.Git.Mirror(url, path)
+	})
+}
+
+func (g *planGit) RemoteUpdate(path string) error {
+	action := g.record("remote update", path)
+	return g.retry(action, func() error {
+		return g.Git.RemoteUpdate(path)
+	})
+}
+
+func (g *planGit) WorktreeAdd(repoPath string, path string, branch string, options *CloneOptions) error {
+	g.record("worktree add", repoPath, append([]string{path, branch}, sparseArgs(options)...)...)
+	return g.Git.WorktreeAdd(repoPath, path, branch, options)
+}
+
+func (g *planGit) WorktreeAddDetached(repoPath string, path string, ref string, options *CloneOptions) error {
+	g.record("worktree add", repoPath, append([]string{"--detach", path, ref}, sparseArgs(options)...)...)
+	return g.Git.WorktreeAddDetached(repoPath, path, ref, options)
+}
+
+// sparseArgs describe sparse checkout of worktree in the plan
+func sparseArgs(options *CloneOptions) []string {
+	if options == nil || len(options.Sparse) == 0 {
+		return nil
+	}
+	return []string{"--sparse=" + strings.Join(options.Sparse, ",")}
+}
+
+func (g *planGit) FetchRef(path string, ref string) error {
+	action := g.record("fetch", path, ref)
+	return g.retry(action, func() error {
+		return g.Git.FetchRef(path, ref)
+	})
+}
+
+func (g *planGit) CheckoutDetached(path string, ref string) error {
+	g.record("checkout", path, "--detach", ref)
+	return g.Git.CheckoutDetached(path, ref)
+}
+
+func (g *planGit) Fetch(path string) error {
+	action := g.record("fetch", path)
+	return g.retry(action, func() error {
+		return g.Git.Fetch(path)
+	})
+}
+
+func (g *planGit) Checkout(path string, branch string) error {
+	g.record("checkout", path, branch)
+	return g.Git.Checkout(path, branch)
+}
+
+func (g *planGit) Pull(path string, strategy string) error {
+	action := g.record("pull", path, strategy)
+	return g.retry(action, func() error {
+		return g.Git.Pull(path, strategy)
+	})
+}
+
+func (g *planGit) Stash(path string) error {
+	g.record("stash", path)
+	return g.Git.Stash(path)
+}
@@ -0,0 +1,120 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package clone
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// testPlan records actions of dry run sync of one project into the plan
+func testPlan(t *testing.T) *Plan {
+	t.Helper()
+	setTestConfig(t, &ConfigStruct{DryRun: true, Layout: LayoutClones})
+	savedBackend := gitBackend
+	t.Cleanup(func() {
+		gitBackend = savedBackend
+	})
+	gitBackend = &binaryGit{}
+
+	plan := newPlan()
+	skipped := &PlanProject{Path: "group/archived", WebURL: "https://gitlab.example.com/group/archived", SkippedBy: "archived is true"}
+	project := &PlanProject{Path: "group/tool", WebURL: "https://gitlab.example.com/group/tool", Matched: true, MatchedBy: "^group/"}
+	// Projects are written sorted by path
+	plan.addProject(project)
+	plan.addProject(skipped)
+
+	branch := &PlanBranch{Name: "main", Path: "group/tool", Default: true, Matched: true}
+	project.addBranch(branch)
+	project.addBranch(&PlanBranch{Name: "stale", Path: "group/tool", SkippedBy: "last commit 90 days ago"})
+	git := newPlanGit(&branch.Actions)
+	if err := git.Clone("https://gitlab.example.com/group/tool.git", "main", "group/tool", &CloneOptions{Depth: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := git.Checkout("group/tool", "main"); err != nil {
+		t.Fatal(err)
+	}
+	return plan
+}
+
+func TestPlanWrite(t *testing.T) {
+	decoders := map[string]func(data []byte, plan *Plan) error{
+		OutputJSON: func(data []byte, plan *Plan) error {
+			return json.Unmarshal(data, plan)
+		},
+		OutputYAML: func(data []byte, plan *Plan) error {
+			return yaml.Unmarshal(data, plan)
+		},
+	}
+	for format, decode := range decoders {
+		t.Run(format, func(t *testing.T) {
+			var buffer bytes.Buffer
+			if err := testPlan(t).Write(&buffer, format); err != nil {
+				t.Fatal(err)
+			}
+			var plan Plan
+			if err := decode(buffer.Bytes(), &plan); err != nil {
+				t.Fatalf("%s output isn't decoded: %v\n%s", format, err, buffer.String())
+			}
+
+			if !plan.DryRun || len(plan.Projects) != 2 {
+				t.Fatalf("plan is %+v", &plan)
+			}
+			skipped, project := plan.Projects[0], plan.Projects[1]
+			if skipped.Path != "group/archived" || skipped.Matched || skipped.SkippedBy != "archived is true" {
+				t.Errorf("skipped project is %+v", skipped)
+			}
+			if project.Path != "group/tool" || !project.Matched || project.MatchedBy != "^group/" || len(project.Branches) != 2 {
+				t.Fatalf("project is %+v", project)
+			}
+			if stale := project.Branches[1]; stale.Matched || stale.SkippedBy != "last commit 90 days ago" {
+				t.Errorf("skipped branch is %+v", stale)
+			}
+
+			var actions []string
+			for _, action := range project.Branches[0].Actions {
+				actions = append(actions, action.Operation+" "+action.Path+" "+strings.Join(action.Args, " "))
+			}
+			want := []string{
+				"clone ./ https://gitlab.example.com/group/tool.git main group/tool --depth 1",
+				"checkout group/tool main",
+			}
+			if strings.Join(actions, "\n") != strings.Join(want, "\n") {
+				t.Errorf("actions are %q, want %q", actions, want)
+			}
+			if attempts := project.Branches[0].Actions[0].Attempts; attempts != 1 {
+				t.Errorf("clone attempts %d, want 1", attempts)
+			}
+		})
+	}
+}
+
+func TestWriteOutputUnknownFormat(t *testing.T) {
+	var buffer bytes.Buffer
+	err := WriteOutput(&buffer, "xml", []string{"a"})
+	if err == nil || !strings.Contains(err.Error(), `unknown output format "xml"`) {
+		t.Errorf("error is %v, want unknown format", err)
+	}
+	if buffer.Len() > 0 {
+		t.Errorf("output %q is written in unknown format", buffer.String())
+	}
+}
@@ -0,0 +1,408 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package clone
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	upstreamMutex sync.Mutex
+	// projectsListed is true when API returned the whole projects list
+	projectsListed bool
+	// upstreamRepos holds paths of all projects returned by API
+	upstreamRepos map[string]bool
+	// upstreamBranches holds paths of all branches returned by API for repos which branches were listed
+	upstreamBranches map[string]map[string]bool
+)
+
+func resetUpstream() {
+	upstreamMutex.Lock()
+	defer upstreamMutex.Unlock()
+	upstreamRepos = make(map[string]bool)
+	upstreamBranches = make(map[string]map[string]bool)
+}
+
+func rememberUpstreamRepo(repoPath string) {
+	upstreamMutex.Lock()
+	defer upstreamMutex.Unlock()
+	upstreamRepos[repoPath] = true
+}
+
+func rememberUpstreamBranches(repoPath string, branchPaths []string) {
+	upstreamMutex.Lock()
+	defer upstreamMutex.Unlock()
+	paths := make(map[string]bool)
+	for _, branchPath := range branchPaths {
+		paths[filepath.Clean(branchPath)] = true
+	}
+	upstreamBranches[repoPath] = paths
+}
+
+// pruneTarget is a local repo of a project, mainPath is its submodule, clone or mirror
+type pruneTarget struct {
+	repoPath string
+	mainPath string
+	// bare mirrors have no working tree to be dirty
+	bare   bool
+	remove func() error
+}
+
+// prune removes local repos of projects and worktrees of branches deleted upstream,
+// dirty ones are never removed
+func prune() {
+	if config.Incremental {
+		log.Warn("Prune is skipped in incremental mode, unchanged projects aren't listed")
+		return
+	}
+	if !projectsListed {
+		log.Warn("Prune is skipped, projects list is incomplete")
+		return
+	}
+
+	if config.DryRun {
+		log.Info("Prune in dry run mode, nothing will be removed")
+	}
+
+	if targets, err := findPruneTargets(); err != nil {
+		report.fail("", "", "./", fmt.Errorf("prune: %w", err))
+	} else {
+		pruneProjects(targets)
+	}
+	pruneWorktrees()
+}
+
+// findPruneTargets returns local repos of the configured layout in the current directory
+func findPruneTargets() ([]*pruneTarget, error) {
+	var targets []*pruneTarget
+	switch config.Layout {
+	case LayoutClones:
+		clonePaths, err := findClones("./")
+		if err != nil {
+			return nil, err
+		}
+		for _, clonePath := range clonePaths {
+			targets = append(targets, &pruneTarget{
+				repoPath: mainRepoPath(clonePath),
+				mainPath: clonePath,
+				remove: func() error {
+					return runCommand("./", "rm", "-rf", clonePath)
+				},
+			})
+		}
+	case LayoutMirror:
+		mirrorPaths, err := findMirrors("./")
+		if err != nil {
+			return nil, err
+		}
+		for _, mirrorPath := range mirrorPaths {
+			targets = append(targets, &pruneTarget{
+				repoPath: mirrorRepoPath(mirrorPath),
+				mainPath: mirrorPath,
+				bare:     true,
+				remove: func() error {
+					return runCommand("./", "rm", "-rf", mirrorPath)
+				},
+			})
+		}
+	default:
+		for _, submodule := range listSubmodules() {
+			targets = append(targets, &pruneTarget{
+				repoPath: mainRepoPath(submodule.path),
+				mainPath: submodule.path,
+				remove: func() error {
+					return removeSubmodule(submodule.name, submodule.path)
+				},
+			})
+		}
+	}
+	return targets, nil
+}
+
+// mainRepoPath returns repo path of submodule or clone, with expanded branches it is their parent directory
+func mainRepoPath(mainPath string) string {
+	if config.ExpandBranches {
+		return filepath.Dir(mainPath)
+	}
+	return mainPath
+}
+
+// pruneProjects removes repos of projects not returned by API. Repos out of repos regexps scope aren't
+// managed by heydevops and aren't touched. Projects may be missing because groups or filters were narrowed,
+// so removal must be confirmed, in dry run they are only reported
+func pruneProjects(targets []*pruneTarget) {
+	var deleted []*pruneTarget
+	var deletedPaths []string
+	for _, target := range targets {
+		if upstreamRepos[target.repoPath] || !checkSkipCloneRegexps(&reposSkipCloneRegexList, target.repoPath) {
+			continue
+		}
+		deleted = append(deleted, target)
+		deletedPaths = append(deletedPaths, target.mainPath)
+	}
+	if len(deleted) == 0 {
+		return
+	}
+
+	if !config.DryRun && (config.ConfirmPrune == nil || !config.ConfirmPrune(deletedPaths)) {
+		for _, target := range deleted {
+			report.skip(target.repoPath, target.branch(), target.mainPath, "deleted upstream, prune not confirmed")
+		}
+		return
+	}
+
+	for _, target := range deleted {
+		log.WithFields(logrus.Fields{
+			"path": target.mainPath,
+			"repo": target.repoPath,
+		}).Info("project deleted upstream, pruning")
+
+		if err := target.prune(); err != nil {
+			if errors.Is(err, errDirty) {
+				report.skip(target.repoPath, target.branch(), target.mainPath, err.Error())
+			} else {
+				report.fail(target.repoPath, target.branch(), target.mainPath, fmt.Errorf("prune: %w", err))
+			}
+			continue
+		}
+		report.prune(target.repoPath, target.branch(), target.mainPath)
+	}
+}
+
+// branch names results of the target, mirrors are reported without branch as they are synced
+func (t *pruneTarget) branch() string {
+	if t.bare {
+		return ""
+	}
+	return "*"
+}
+
+// prune removes worktrees of the repo and the repo itself, everything is checked before removing anything
+func (t *pruneTarget) prune() error {
+	if !t.bare {
+		worktreePaths, err := listWorktrees(t.mainPath)
+		if err != nil {
+			return err
+		}
+		for _, worktreePath := range append(worktreePaths, t.mainPath) {
+			if err := checkClean(worktreePath); err != nil {
+				return err
+			}
+		}
+		for _, worktreePath := range worktreePaths {
+			if err := runCommand(t.mainPath, "git", "worktree", "remove", absPath(worktreePath)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := t.remove(); err != nil {
+		return err
+	}
+	if config.ExpandBranches && !config.DryRun {
+		// Remove repo directory if nothing else left there
+		_ = os.Remove(filepath.Dir(t.mainPath))
+	}
+	return nil
+}
+
+type submodule struct {
+	name string
+	path string
+}
+
+// listSubmodules returns submodules of .gitmodules in the current directory
+func listSubmodules() []*submodule {
+	output, err := runOutput("./", "git", "config", "-f", ".gitmodules", "--get-regexp", `^submodule\..*\.path$`)
+	if err != nil {
+		// No .gitmodules or no submodules in it
+		log.WithFields(logrus.Fields{
+			"err": err,
+		}).Debug("no submodules found")
+		return nil
+	}
+
+	var submodules []*submodule
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if fields := strings.SplitN(line, " ", 2); len(fields) == 2 {
+			name := strings.TrimSuffix(strings.TrimPrefix(fields[0], "submodule."), ".path")
+			submodules = append(submodules, &submodule{name: name, path: fields[1]})
+		}
+	}
+	return submodules
+}
+
+func removeSubmodule(name string, submodulePath string) error {
+	gitMutex.Lock()
+	defer gitMutex.Unlock()
+
+	if err := runCommand("./", "git", "submodule", "deinit", "-f", "--", submodulePath); err != nil {
+		return err
+	}
+	if err := runCommand("./", "git", "rm", "-f", "--", submodulePath); err != nil {
+		return err
+	}
+	return runCommand("./", "rm", "-rf", filepath.Join(".git", "modules", name))
+}
+
+// findClones returns paths of repositories cloned under root, linked worktrees and
+// nested repositories aren't included
+func findClones(root string) ([]string, error) {
+	var clonePaths []string
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() || path == root {
+			return nil
+		}
+		if strings.HasPrefix(entry.Name(), ".") {
+			return filepath.SkipDir
+		}
+		// Worktrees and submodules have .git file, clones have .git directory
+		if info, err := os.Stat(filepath.Join(path, ".git")); err == nil {
+			if info.IsDir() {
+				clonePaths = append(clonePaths, filepath.Clean(path))
+			}
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return clonePaths, err
+}
+
+// findMirrors returns paths of bare mirrors and wiki mirrors under root
+func findMirrors(root string) ([]string, error) {
+	var mirrorPaths []string
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() || path == root {
+			return nil
+		}
+		if strings.HasPrefix(entry.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if strings.HasSuffix(path, mirrorSuffix) && pathExists(filepath.Join(path, "HEAD")) {
+			mirrorPaths = append(mirrorPaths, filepath.Clean(path))
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return mirrorPaths, err
+}
+
+func pruneWorktrees() {
+	upstreamMutex.Lock()
+	defer upstreamMutex.Unlock()
+
+	for repoPath, branchPaths := range upstreamBranches {
+		// Any worktree of the repo lists all of them
+		var mainPath string
+		for branchPath := range branchPaths {
+			if pathExists(filepath.Join(branchPath, ".git")) {
+				mainPath = branchPath
+				break
+			}
+		}
+		if mainPath == "" {
+			continue
+		}
+
+		worktreePaths, err := listWorktrees(mainPath)
+		if err != nil {
+			report.fail(repoPath, "*", repoPath, fmt.Errorf("prune: %w", err))
+			continue
+		}
+
+		for _, worktreePath := range worktreePaths {
+			if branchPaths[worktreePath] {
+				continue
+			}
+			// Worktrees which aren't mapped into the current directory are never taken for deleted ones
+			if !filepath.IsLocal(worktreePath) {
+				log.WithFields(logrus.Fields{
+					"repo":     repoPath,
+					"worktree": worktreePath,
+				}).Warn("worktree is out of the current directory, not pruned")
+				continue
+			}
+
+			log.WithFields(logrus.Fields{
+				"repo":     repoPath,
+				"worktree": worktreePath,
+			}).Info("branch deleted upstream, pruning")
+
+			// Worktree is reported by the branch, tag or merge request its directory was named after
+			result, _ := getWorktreeName(worktreePath)
+			if err := checkClean(worktreePath); err != nil {
+				report.skip(repoPath, result, worktreePath, err.Error())
+				continue
+			}
+			if err := runCommand(mainPath, "git", "worktree", "remove", absPath(worktreePath)); err != nil {
+				report.fail(repoPath, result, worktreePath, fmt.Errorf("prune: %w", err))
+				continue
+			}
+			report.prune(repoPath, result, worktreePath)
+		}
+
+		if err := runCommand(mainPath, "git", "worktree", "prune"); err != nil {
+			report.fail(repoPath, "*", repoPath, fmt.Errorf("prune: %w", err))
+		}
+	}
+}
+
+// relativeTo returns target path relative to base directory, both are relative to the current directory
+func relativeTo(base string, target string) string {
+	if relPath, err := filepath.Rel(base, target); err == nil {
+		return relPath
+	}
+	return absPath(target)
+}
+
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+var errDirty = errors.New("has uncommitted changes, not pruned")
+
+func checkClean(path string) error {
+	if !pathExists(path) {
+		return nil
+	}
+	dirty, err := isDirty(path)
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return fmt.Errorf("%s %w", path, errDirty)
+	}
+	return nil
+}
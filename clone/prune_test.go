@@ -0,0 +1,235 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package clone
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// setPruneTest sets config up for prune of projects matched by repos regexps with upstream ones listed
+func setPruneTest(t *testing.T, configPtr *ConfigStruct, listed bool, upstream ...string) {
+	t.Helper()
+	savedReport, savedListed := report, projectsListed
+	t.Cleanup(func() {
+		report, projectsListed = savedReport, savedListed
+	})
+
+	setTestConfig(t, configPtr)
+	compileRegexps()
+	report = newReport()
+	resetUpstream()
+	for _, repoPath := range upstream {
+		rememberUpstreamRepo(repoPath)
+	}
+	projectsListed = listed
+}
+
+// pruneResults returns status and path of every result sorted by path
+func pruneResults() []string {
+	var results []string
+	for _, result := range report.Results {
+		results = append(results, result.Status+" "+result.Path)
+	}
+	sort.Strings(results)
+	return results
+}
+
+func checkPaths(t *testing.T, exist []string, removed []string) {
+	t.Helper()
+	for _, path := range exist {
+		if !pathExists(path) {
+			t.Errorf("%s was removed", path)
+		}
+	}
+	for _, path := range removed {
+		if pathExists(path) {
+			t.Errorf("%s wasn't removed", path)
+		}
+	}
+}
+
+func confirmed(paths []string) bool {
+	return true
+}
+
+func TestPruneClones(t *testing.T) {
+	tests := []struct {
+		name    string
+		listed  bool
+		confirm func(paths []string) bool
+		dryRun  bool
+		removed []string
+		results []string
+	}{
+		{"listing failed", false, confirmed, false, nil, nil},
+		{"not confirmed", true, nil, false, nil, []string{
+			"skipped group/dirty", "skipped group/gone",
+		}},
+		{"declined", true, func(paths []string) bool { return false }, false, nil, []string{
+			"skipped group/dirty", "skipped group/gone",
+		}},
+		{"dry run", true, nil, true, nil, []string{
+			"pruned group/gone", "skipped group/dirty",
+		}},
+		{"confirmed", true, confirmed, false, []string{"group/gone"}, []string{
+			"pruned group/gone", "skipped group/dirty",
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chdirTemp(t)
+			for _, path := range []string{"group/kept", "group/gone", "group/dirty", "other/foreign"} {
+				initClone(t, path)
+			}
+			if err := os.WriteFile("group/dirty/new.txt", []byte("work in progress"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			configPtr := &ConfigStruct{Layout: LayoutClones, DryRun: test.dryRun, ConfirmPrune: test.confirm}
+			configPtr.Repos.Clone = []string{"^group/"}
+			setPruneTest(t, configPtr, test.listed, "group/kept")
+
+			var asked []string
+			if test.confirm != nil {
+				configPtr.ConfirmPrune = func(paths []string) bool {
+					asked = paths
+					return test.confirm(paths)
+				}
+			}
+
+			prune()
+
+			var exist []string
+			for _, path := range []string{"group/kept", "group/gone", "group/dirty", "other/foreign"} {
+				if !strings.Contains(strings.Join(test.removed, " "), path) {
+					exist = append(exist, path)
+				}
+			}
+			checkPaths(t, exist, test.removed)
+			if got := pruneResults(); strings.Join(got, ", ") != strings.Join(test.results, ", ") {
+				t.Errorf("results %v, want %v", got, test.results)
+			}
+			// Repos out of regexps scope are never offered for removal
+			if test.listed && test.confirm != nil && !test.dryRun && strings.Join(asked, " ") != "group/dirty group/gone" {
+				t.Errorf("asked to confirm %v", asked)
+			}
+		})
+	}
+}
+
+func TestPruneDirtyWorktree(t *testing.T) {
+	chdirTemp(t)
+	initClone(t, "group/gone/main")
+	runGit(t, "group/gone/main", "branch", "feature")
+	runGit(t, "group/gone/main", "worktree", "add", "--quiet", "../feature", "feature")
+	if err := os.WriteFile("group/gone/feature/new.txt", []byte("work in progress"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	configPtr := &ConfigStruct{Layout: LayoutClones, ExpandBranches: true, ConfirmPrune: confirmed}
+	configPtr.Repos.Clone = []string{".*"}
+	setPruneTest(t, configPtr, true)
+
+	prune()
+
+	// Clean main clone isn't removed while its worktree is dirty
+	checkPaths(t, []string{"group/gone/main", "group/gone/feature/new.txt"}, nil)
+	if len(report.Results) != 1 || report.Results[0].Status != StatusSkipped || !strings.Contains(report.Results[0].Cause, errDirty.Error()) {
+		t.Errorf("results %v, want dirty worktree skipped", pruneResults())
+	}
+
+	if err := os.Remove("group/gone/feature/new.txt"); err != nil {
+		t.Fatal(err)
+	}
+	report = newReport()
+	prune()
+	checkPaths(t, nil, []string{"group/gone"})
+}
+
+func TestPruneSubmodules(t *testing.T) {
+	dir := chdirTemp(t)
+	initClone(t, "upstream")
+	initClone(t, "super")
+	for _, path := range []string{"group/kept", "group/gone"} {
+		runGit(t, "super", "-c", "protocol.file.allow=always", "submodule", "add", "--quiet", filepath.Join(dir, "upstream"), path)
+	}
+	runGit(t, "super", "commit", "--quiet", "-m", "submodules")
+	if err := os.Chdir("super"); err != nil {
+		t.Fatal(err)
+	}
+
+	configPtr := &ConfigStruct{ConfirmPrune: confirmed}
+	configPtr.Repos.Clone = []string{".*"}
+	setPruneTest(t, configPtr, true, "group/kept")
+
+	prune()
+
+	checkPaths(t, []string{"group/kept"}, []string{"group/gone", ".git/modules/group/gone"})
+	if got := pruneResults(); strings.Join(got, ", ") != "pruned group/gone" {
+		t.Errorf("results %v, want group/gone pruned", got)
+	}
+	var paths []string
+	for _, submodule := range listSubmodules() {
+		paths = append(paths, submodule.path)
+	}
+	if strings.Join(paths, " ") != "group/kept" {
+		t.Errorf("submodules left %v, want group/kept", paths)
+	}
+}
+
+func TestPruneMirrors(t *testing.T) {
+	chdirTemp(t)
+	for _, path := range []string{"group/kept.git", "group/gone.git", "group/gone.wiki.git"} {
+		runGit(t, ".", "init", "--quiet", "--bare", path)
+	}
+
+	configPtr := &ConfigStruct{Layout: LayoutMirror, ConfirmPrune: confirmed}
+	configPtr.Repos.Clone = []string{".*"}
+	setPruneTest(t, configPtr, true, "group/kept")
+
+	prune()
+
+	checkPaths(t, []string{"group/kept.git"}, []string{"group/gone.git", "group/gone.wiki.git"})
+	if got := pruneResults(); strings.Join(got, ", ") != "pruned group/gone.git, pruned group/gone.wiki.git" {
+		t.Errorf("results %v", got)
+	}
+}
+
+func TestCheckClean(t *testing.T) {
+	setTestConfig(t, &ConfigStruct{})
+	chdirTemp(t)
+	initClone(t, "repo")
+
+	if err := checkClean("repo"); err != nil {
+		t.Errorf("clean repo: %v", err)
+	}
+	if err := checkClean("missing"); err != nil {
+		t.Errorf("missing path: %v", err)
+	}
+	if err := os.WriteFile("repo/new.txt", nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkClean("repo"); !errors.Is(err, errDirty) {
+		t.Errorf("dirty repo: %v, want %v", err, errDirty)
+	}
+}
@@ -0,0 +1,159 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package clone
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+)
+
+const (
+	StatusSuccess = "success"
+	StatusSkipped = "skipped"
+	StatusFailed  = "failed"
+	StatusPruned  = "pruned"
+)
+
+// Result is an outcome of processing single repo or branch
+type Result struct {
+	Repo   string
+	Branch string
+	Path   string
+	Status string
+	Cause  string
+}
+
+// Report collects results of the whole run
+type Report struct {
+	mutex        sync.Mutex
+	Results      []*Result
+	FilteredOut  int
+	ProjectsSeen int
+	// Retries counts retries of API calls and git operations
+	Retries map[string]int
+	Plan    *Plan
+}
+
+func newReport() *Report {
+	return &Report{Plan: newPlan(), Retries: make(map[string]int)}
+}
+
+func (r *Report) add(result *Result) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.Results = append(r.Results, result)
+}
+
+func (r *Report) success(repo string, branch string, path string, outcome string) {
+	r.add(&Result{Repo: repo, Branch: branch, Path: path, Status: StatusSuccess, Cause: outcome})
+}
+
+func (r *Report) skip(repo string, branch string, path string, cause string) {
+	r.add(&Result{Repo: repo, Branch: branch, Path: path, Status: StatusSkipped, Cause: cause})
+}
+
+func (r *Report) fail(repo string, branch string, path string, err error) {
+	r.add(&Result{Repo: repo, Branch: branch, Path: path, Status: StatusFailed, Cause: err.Error()})
+}
+
+func (r *Report) prune(repo string, branch string, path string) {
+	r.add(&Result{Repo: repo, Branch: branch, Path: path, Status: StatusPruned, Cause: dryRunOutcome("")})
+}
+
+// dryRunOutcome marks outcome of changes which weren't really made in dry run
+func dryRunOutcome(outcome string) string {
+	switch {
+	case !config.DryRun:
+		return outcome
+	case outcome == "":
+		return "dry run"
+	default:
+		return outcome + " (dry run)"
+	}
+}
+
+func (r *Report) projectSeen(filteredOut bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.ProjectsSeen++
+	if filteredOut {
+		r.FilteredOut++
+	}
+}
+
+func (r *Report) retried(kind string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.Retries[kind]++
+}
+
+// Count returns number of results with status
+func (r *Report) Count(status string) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	count := 0
+	for _, result := range r.Results {
+		if result.Status == status {
+			count++
+		}
+	}
+	return count
+}
+
+// Failed reports whether anything failed during the run
+func (r *Report) Failed() bool {
+	return r.Count(StatusFailed) > 0
+}
+
+// Print writes summary table, failures go first
+func (r *Report) Print(writer io.Writer) {
+	r.mutex.Lock()
+	results := make([]*Result, len(r.Results))
+	copy(results, r.Results)
+	projectsSeen, filteredOut := r.ProjectsSeen, r.FilteredOut
+	apiRetries, gitRetries := r.Retries[RetryAPI], r.Retries[RetryGit]
+	r.mutex.Unlock()
+
+	statusOrder := map[string]int{StatusFailed: 0, StatusSkipped: 1, StatusPruned: 2, StatusSuccess: 3}
+	sort.SliceStable(results, func(i, j int) bool {
+		if statusOrder[results[i].Status] != statusOrder[results[j].Status] {
+			return statusOrder[results[i].Status] < statusOrder[results[j].Status]
+		}
+		if results[i].Repo != results[j].Repo {
+			return results[i].Repo < results[j].Repo
+		}
+		return results[i].Branch < results[j].Branch
+	})
+
+	tabWriter := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tabWriter, "STATUS\tREPO\tBRANCH\tPATH\tCAUSE")
+	for _, result := range results {
+		fmt.Fprintf(tabWriter, "%s\t%s\t%s\t%s\t%s\n", result.Status, result.Repo, result.Branch, result.Path, result.Cause)
+	}
+	_ = tabWriter.Flush()
+
+	fmt.Fprintf(writer, "\nProjects found: %d, filtered out by repos rules: %d\n", projectsSeen, filteredOut)
+	fmt.Fprintf(writer, "Succeeded: %d, skipped: %d, pruned: %d, failed: %d\n",
+		r.Count(StatusSuccess), r.Count(StatusSkipped), r.Count(StatusPruned), r.Count(StatusFailed))
+	if apiRetries > 0 || gitRetries > 0 {
+		fmt.Fprintf(writer, "Retries of API calls: %d, git operations: %d\n", apiRetries, gitRetries)
+	}
+}
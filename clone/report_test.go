@@ -0,0 +1,67 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package clone
+
+import (
+	"testing"
+)
+
+func TestDryRunOutcomes(t *testing.T) {
+	chdirTemp(t)
+
+	tests := []struct {
+		name   string
+		config ConfigStruct
+		sync   func() (string, error)
+		want   string
+	}{
+		{"clone", ConfigStruct{DryRun: true, Layout: LayoutClones}, func() (string, error) {
+			return syncBranch(&binaryGit{}, "group/tool", "upstream", "main", "group/tool/main", true, "main")
+		}, "cloned (dry run)"},
+		{"submodule", ConfigStruct{DryRun: true}, func() (string, error) {
+			return syncBranch(&binaryGit{}, "group/tool", "upstream", "main", "group/tool/main", true, "main")
+		}, "submodule added (dry run)"},
+		{"worktree", ConfigStruct{DryRun: true, Layout: LayoutClones}, func() (string, error) {
+			return syncBranch(&binaryGit{}, "group/tool", "upstream", "dev", "group/tool/dev", false, "main")
+		}, "worktree added (dry run)"},
+		{"detached worktree", ConfigStruct{DryRun: true}, func() (string, error) {
+			return syncDetached(&binaryGit{}, "group/tool/main", "refs/tags/v1", "", "group/tool/v1", nil)
+		}, "worktree added (dry run)"},
+		{"real clone", ConfigStruct{Layout: LayoutClones}, func() (string, error) {
+			return dryRunOutcome("cloned"), nil
+		}, "cloned"},
+		{"empty outcome", ConfigStruct{DryRun: true}, func() (string, error) {
+			return dryRunOutcome(""), nil
+		}, "dry run"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			configPtr := test.config
+			setTestConfig(t, &configPtr)
+
+			outcome, err := test.sync()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if outcome != test.want {
+				t.Errorf("outcome %q, want %q", outcome, test.want)
+			}
+			checkPaths(t, nil, []string{"group"})
+		})
+	}
+}
@@ -0,0 +1,71 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package clone
+
+import (
+	"fmt"
+	re "regexp"
+	"time"
+
+	. "github.com/Logunov/heydevops/helpers"
+)
+
+const (
+	RetryAPI = "api"
+	RetryGit = "git"
+)
+
+// gitRetry sets retries of git network operations up
+var gitRetry *RetryConfig
+
+// retryableGitRegexp matches git and go-git errors caused by network or overloaded server
+var retryableGitRegexp = re.MustCompile(`(?i)could not resolve host|connection (timed out|reset|refused)|` +
+	`operation timed out|early eof|remote end hung up|rpc failed|unexpected disconnect|` +
+	`returned error: (429|5\d\d)|status code: (429|5\d\d)|tls handshake timeout|i/o timeout|broken pipe`)
+
+// newRetries returns copies of configured retries for API calls and git operations
+// counting retries into the report
+func newRetries() (*RetryConfig, *RetryConfig) {
+	apiRetry := config.Retry
+	apiRetry.OnRetry = func(what string, attempt int, delay time.Duration, err error) {
+		report.retried(RetryAPI)
+	}
+	gitRetry := config.Retry
+	gitRetry.OnRetry = func(what string, attempt int, delay time.Duration, err error) {
+		report.retried(RetryGit)
+	}
+	return &apiRetry, &gitRetry
+}
+
+func retryableGit(err error) (bool, time.Duration) {
+	return retryableGitRegexp.MatchString(err.Error()), 0
+}
+
+// retriesNote describes retries of actions for the summary, it is empty without retries
+func retriesNote(actions []*PlanAction) string {
+	retries := 0
+	for _, action := range actions {
+		if action.Attempts > 1 {
+			retries += action.Attempts - 1
+		}
+	}
+	if retries == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d retries", retries)
+}
@@ -0,0 +1,109 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package clone
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/Logunov/heydevops/helpers"
+)
+
+// flakyGit runs git binary, but the first clone and submodule add fail after doing their work
+type flakyGit struct {
+	binaryGit
+	failed bool
+}
+
+func (g *flakyGit) fail(err error) error {
+	if err != nil || g.failed {
+		return err
+	}
+	g.failed = true
+	return errors.New("fatal: early EOF")
+}
+
+func (g *flakyGit) Clone(url string, branch string, path string, options *CloneOptions) error {
+	return g.fail(g.binaryGit.Clone(url, branch, path, options))
+}
+
+func (g *flakyGit) SubmoduleAdd(url string, branch string, path string, options *CloneOptions) error {
+	return g.fail(g.binaryGit.SubmoduleAdd(url, branch, path, options))
+}
+
+// setRetryTest makes git operations of the test run by flakyGit with retries
+func setRetryTest(t *testing.T) {
+	t.Helper()
+	savedBackend, savedRetry, savedEnv, savedReport := gitBackend, gitRetry, gitEnv, report
+	t.Cleanup(func() {
+		gitBackend, gitRetry, gitEnv, report = savedBackend, savedRetry, savedEnv, savedReport
+	})
+
+	setTestConfig(t, &ConfigStruct{})
+	SetLogger(log)
+	report = newReport()
+	gitBackend = &flakyGit{}
+	gitRetry = &RetryConfig{Attempts: 2}
+	gitEnv = []string{"GIT_CONFIG_COUNT=1", "GIT_CONFIG_KEY_0=protocol.file.allow", "GIT_CONFIG_VALUE_0=always"}
+}
+
+func TestRetryClone(t *testing.T) {
+	dir := chdirTemp(t)
+	initClone(t, "upstream")
+	setRetryTest(t)
+
+	var actions []*PlanAction
+	if err := newPlanGit(&actions).Clone(filepath.Join(dir, "upstream"), "main", "group/tool", nil); err != nil {
+		t.Fatalf("retried clone into partial directory: %v", err)
+	}
+	if len(actions) != 1 || actions[0].Attempts != 2 {
+		t.Errorf("actions %+v, want one clone attempted twice", actions)
+	}
+	checkPaths(t, []string{"group/tool/.git"}, nil)
+}
+
+func TestRetrySubmoduleAdd(t *testing.T) {
+	dir := chdirTemp(t)
+	initClone(t, "upstream")
+	initClone(t, "super")
+	if err := os.Chdir("super"); err != nil {
+		t.Fatal(err)
+	}
+	setRetryTest(t)
+
+	var actions []*PlanAction
+	if err := newPlanGit(&actions).SubmoduleAdd(filepath.Join(dir, "upstream"), "main", "group/tool", nil); err != nil {
+		t.Fatalf("retried submodule add after partial one: %v", err)
+	}
+	if len(actions) != 1 || actions[0].Attempts != 2 {
+		t.Errorf("actions %+v, want one submodule add attempted twice", actions)
+	}
+	var paths []string
+	for _, submodule := range listSubmodules() {
+		paths = append(paths, submodule.path)
+	}
+	if strings.Join(paths, " ") != "group/tool" {
+		t.Errorf("submodules %v, want group/tool once", paths)
+	}
+	if gitmodules, err := os.ReadFile(".gitmodules"); err != nil || strings.Count(string(gitmodules), "[submodule") != 1 {
+		t.Errorf(".gitmodules %q, want one section: %v", gitmodules, err)
+	}
+}
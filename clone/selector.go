@@ -0,0 +1,148 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package clone
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Logunov/heydevops/provider"
+)
+
+// ReposStruct selects projects by path regexps and by their metadata,
+// nil or empty metadata rules aren't applied
+type ReposStruct struct {
+	SkipCloneStringsStruct
+	// Topics selects projects having any of the topics
+	Topics     []string
+	Archived   *bool
+	Visibility []string
+	// ActiveWithinDays selects projects with last activity not older than that
+	ActiveWithinDays int
+	EmptyRepo        *bool
+	Forks            *bool
+	// NamespaceKind is user or group
+	NamespaceKind string
+}
+
+// matchRepoMetadata returns the reason project doesn't match repos metadata rules,
+// it is empty when project matches
+func matchRepoMetadata(project *provider.Project) string {
+	repos := &config.Repos
+
+	if len(repos.Topics) > 0 && !containsAnyFold(project.Topics, repos.Topics) {
+		return "has none of topics " + strings.Join(repos.Topics, ", ")
+	}
+	if repos.Archived != nil && *repos.Archived != project.Archived {
+		return fmt.Sprintf("archived is %t", project.Archived)
+	}
+	if len(repos.Visibility) > 0 && !containsFold(repos.Visibility, project.Visibility) {
+		return "visibility is " + project.Visibility
+	}
+	if repos.ActiveWithinDays > 0 {
+		if project.LastActivityAt == nil {
+			return "last activity is unknown"
+		}
+		if age := time.Since(*project.LastActivityAt); age > time.Duration(repos.ActiveWithinDays)*24*time.Hour {
+			return fmt.Sprintf("inactive for %d days", int(age.Hours()/24))
+		}
+	}
+	if repos.EmptyRepo != nil && *repos.EmptyRepo != project.EmptyRepo {
+		return fmt.Sprintf("empty repo is %t", project.EmptyRepo)
+	}
+	if repos.Forks != nil && *repos.Forks != project.Fork {
+		return fmt.Sprintf("fork is %t", project.Fork)
+	}
+	if repos.NamespaceKind != "" && !strings.EqualFold(repos.NamespaceKind, project.NamespaceKind) {
+		if project.NamespaceKind == "" {
+			return "namespace kind is unknown"
+		}
+		return "namespace kind is " + project.NamespaceKind
+	}
+	return ""
+}
+
+// containsAnyFold reports whether any of values is wanted, case insensitive
+func containsAnyFold(values []string, wanted []string) bool {
+	for _, value := range values {
+		if containsFold(wanted, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// selectBranches returns reasons branches matched by branches regexps are skipped by branches rules,
+// the default branch is always kept. mergeRequests are open ones, they are listed when the rule needs them.
+func selectBranches(branches []*provider.Branch, mergeRequests []*provider.MergeRequest) map[string]string {
+	rules := &config.Branches
+	reasons := make(map[string]string)
+
+	var openMergeRequests map[string]bool
+	if rules.OpenMergeRequest {
+		openMergeRequests = make(map[string]bool)
+		for _, mergeRequest := range mergeRequests {
+			// Branches of forks have the same names but aren't branches of this project
+			if !mergeRequest.Fork {
+				openMergeRequests[mergeRequest.SourceBranch] = true
+			}
+		}
+	}
+
+	var candidates []*provider.Branch
+	for _, branch := range branches {
+		if branch.Default || !checkSkipCloneRegexps(&branchesSkipCloneRegexList, branch.Name) {
+			continue
+		}
+		switch {
+		case rules.ProtectedOnly && !branch.Protected:
+			reasons[branch.Name] = "not protected"
+		case rules.MaxAgeDays > 0 && branch.CommittedAt != nil && time.Since(*branch.CommittedAt) > time.Duration(rules.MaxAgeDays)*24*time.Hour:
+			reasons[branch.Name] = fmt.Sprintf("last commit %d days ago", int(time.Since(*branch.CommittedAt).Hours()/24))
+		case openMergeRequests != nil && !openMergeRequests[branch.Name]:
+			reasons[branch.Name] = "no open merge request"
+		default:
+			candidates = append(candidates, branch)
+		}
+	}
+
+	if rules.Latest > 0 && len(candidates) > rules.Latest {
+		// Branches without commit date go last
+		sort.SliceStable(candidates, func(i, j int) bool {
+			if candidates[i].CommittedAt == nil || candidates[j].CommittedAt == nil {
+				return candidates[j].CommittedAt == nil && candidates[i].CommittedAt != nil
+			}
+			return candidates[i].CommittedAt.After(*candidates[j].CommittedAt)
+		})
+		for _, branch := range candidates[rules.Latest:] {
+			reasons[branch.Name] = fmt.Sprintf("not among %d most recently updated", rules.Latest)
+		}
+	}
+	return reasons
+}
@@ -0,0 +1,126 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package clone
+
+import (
+	re "regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Logunov/heydevops/provider"
+)
+
+func TestMatchRepoMetadata(t *testing.T) {
+	yes, no := true, false
+	recently := time.Now().Add(-24 * time.Hour)
+	longAgo := time.Now().Add(-100 * 24 * time.Hour)
+	project := &provider.Project{
+		Topics:         []string{"Terraform", "infra"},
+		Visibility:     "internal",
+		LastActivityAt: &recently,
+		NamespaceKind:  provider.NamespaceGroup,
+	}
+
+	tests := []struct {
+		name    string
+		repos   ReposStruct
+		project *provider.Project
+		reason  string
+	}{
+		{"no rules", ReposStruct{}, project, ""},
+		{"topic case insensitive", ReposStruct{Topics: []string{"terraform"}}, project, ""},
+		{"no topic", ReposStruct{Topics: []string{"go", "docs"}}, project, "has none of topics go, docs"},
+		{"not archived", ReposStruct{Archived: &no}, project, ""},
+		{"archived only", ReposStruct{Archived: &yes}, project, "archived is false"},
+		{"visibility", ReposStruct{Visibility: []string{"Private", "Internal"}}, project, ""},
+		{"other visibility", ReposStruct{Visibility: []string{"public"}}, project, "visibility is internal"},
+		{"active", ReposStruct{ActiveWithinDays: 30}, project, ""},
+		{"inactive", ReposStruct{ActiveWithinDays: 30}, &provider.Project{LastActivityAt: &longAgo}, "inactive for 100 days"},
+		{"activity unknown", ReposStruct{ActiveWithinDays: 30}, &provider.Project{}, "last activity is unknown"},
+		{"not empty", ReposStruct{EmptyRepo: &no}, &provider.Project{EmptyRepo: true}, "empty repo is true"},
+		{"no forks", ReposStruct{Forks: &no}, &provider.Project{Fork: true}, "fork is true"},
+		{"namespace kind", ReposStruct{NamespaceKind: "Group"}, project, ""},
+		{"user namespace", ReposStruct{NamespaceKind: provider.NamespaceUser}, project, "namespace kind is group"},
+		{"namespace kind unknown", ReposStruct{NamespaceKind: provider.NamespaceUser}, &provider.Project{}, "namespace kind is unknown"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setTestConfig(t, &ConfigStruct{Repos: test.repos})
+			if reason := matchRepoMetadata(test.project); reason != test.reason {
+				t.Errorf("matchRepoMetadata() = %q, want %q", reason, test.reason)
+			}
+		})
+	}
+}
+
+func TestSelectBranches(t *testing.T) {
+	daysAgo := func(days int) *time.Time {
+		at := time.Now().Add(-time.Duration(days) * 24 * time.Hour)
+		return &at
+	}
+	branches := []*provider.Branch{
+		{Name: "main", Default: true, CommittedAt: daysAgo(400)},
+		{Name: "release", Protected: true, CommittedAt: daysAgo(1)},
+		{Name: "feature-a", CommittedAt: daysAgo(2)},
+		{Name: "feature-b", CommittedAt: daysAgo(3)},
+		{Name: "stale", CommittedAt: daysAgo(200)},
+		{Name: "undated"},
+		{Name: "skipped", CommittedAt: daysAgo(1)},
+	}
+	mergeRequests := []*provider.MergeRequest{
+		{SourceBranch: "feature-a"},
+		{SourceBranch: "feature-b", Fork: true},
+	}
+
+	tests := []struct {
+		name  string
+		rules BranchesStruct
+		kept  []string
+	}{
+		{"no rules", BranchesStruct{}, []string{"main", "release", "feature-a", "feature-b", "stale", "undated"}},
+		{"protected only", BranchesStruct{ProtectedOnly: true}, []string{"main", "release"}},
+		{"max age", BranchesStruct{MaxAgeDays: 30}, []string{"main", "release", "feature-a", "feature-b", "undated"}},
+		{"open merge request", BranchesStruct{OpenMergeRequest: true}, []string{"main", "feature-a"}},
+		{"latest", BranchesStruct{Latest: 2}, []string{"main", "release", "feature-a"}},
+		{"latest with undated last", BranchesStruct{Latest: 4}, []string{"main", "release", "feature-a", "feature-b", "stale"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setTestConfig(t, &ConfigStruct{Branches: test.rules})
+			branchesSkipCloneRegexList = SkipCloneRegexStruct{
+				Clone: []*re.Regexp{re.MustCompile(".*")},
+				Skip:  []*re.Regexp{re.MustCompile("^skipped$")},
+			}
+
+			reasons := selectBranches(branches, mergeRequests)
+			var kept []string
+			for _, branch := range branches {
+				if _, skipped := reasons[branch.Name]; !skipped && branch.Name != "skipped" {
+					kept = append(kept, branch.Name)
+				}
+			}
+			if strings.Join(kept, " ") != strings.Join(test.kept, " ") {
+				t.Errorf("kept %v, want %v, reasons %v", kept, test.kept, reasons)
+			}
+			if _, ok := reasons["skipped"]; ok {
+				t.Error("branch out of branches regexps got a reason of rules")
+			}
+		})
+	}
+}
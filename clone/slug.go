@@ -0,0 +1,167 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package clone
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// SlugLenient escapes characters unsafe in file names only, branch names containing slash replacement
+	// may get the same slug as other ones, such collisions are detected. Slugs containing slash replacement
+	// aren't decoded as they are ambiguous
+	SlugLenient = "lenient"
+	// SlugStrict escapes names of characters which would be taken for slash replacement too,
+	// so every slug is decoded back to its name
+	SlugStrict = "strict"
+)
+
+// unsafeSlugChars can't be in file names on some file systems, % escapes them and itself
+const unsafeSlugChars = `%\:*?"<>|`
+
+// slugToken is a slash replacement, escaped or literal character of the slug
+type slugToken struct {
+	text    string
+	literal bool
+}
+
+// encodeSlug replaces slashes of branch or tag name with slash and percent-encodes unsafe characters,
+// strict slugs have literal characters starting slash replacement escaped too
+func encodeSlug(name string, slash string) string {
+	strict := config.SlugEncoding == SlugStrict
+	var tokens []*slugToken
+	for i := 0; i < len(name); i++ {
+		char := name[i]
+		switch {
+		case char == '/' && (slash != "" || !strict):
+			tokens = append(tokens, &slugToken{text: slash})
+		case char < 0x20 || char == 0x7f || char == '/' || strings.IndexByte(unsafeSlugChars, char) >= 0:
+			tokens = append(tokens, &slugToken{text: escapeSlugChar(char)})
+		default:
+			tokens = append(tokens, &slugToken{text: string(char), literal: true})
+		}
+	}
+
+	// decodeSlug takes slash replacement at literal character for a slash, going from the end
+	// escapes only characters which really start it
+	if strict && slash != "" {
+		var tail string
+		for i := len(tokens) - 1; i >= 0; i-- {
+			if tokens[i].literal && strings.HasPrefix(tokens[i].text+tail, slash) {
+				tokens[i].text, tokens[i].literal = escapeSlugChar(tokens[i].text[0]), false
+			}
+			tail = tokens[i].text + tail
+		}
+	}
+
+	var builder strings.Builder
+	for _, token := range tokens {
+		builder.WriteString(token.text)
+	}
+	return builder.String()
+}
+
+func escapeSlugChar(char byte) string {
+	return fmt.Sprintf("%%%02X", char)
+}
+
+// decodeSlug returns name the slug was encoded from, lenient slugs containing slash replacement are ambiguous
+// and aren't decoded, neither are lenient ones with empty slash replacement which drops slashes
+func decodeSlug(slug string, slash string) (string, bool) {
+	if config.SlugEncoding != SlugStrict && (slash == "" || strings.Contains(slug, slash)) {
+		return "", false
+	}
+
+	var builder strings.Builder
+	for i := 0; i < len(slug); {
+		switch {
+		case slash != "" && strings.HasPrefix(slug[i:], slash):
+			builder.WriteByte('/')
+			i += len(slash)
+		case slug[i] == '%':
+			if i+3 > len(slug) {
+				return "", false
+			}
+			char, err := strconv.ParseUint(slug[i+1:i+3], 16, 8)
+			if err != nil {
+				return "", false
+			}
+			builder.WriteByte(byte(char))
+			i += 3
+		default:
+			builder.WriteByte(slug[i])
+			i++
+		}
+	}
+	return builder.String(), true
+}
+
+// getWorktreeName maps worktree directory back to the branch, tag or merge request it was created for,
+// named like results of the report. Directory name must be prefix, slug and suffix, as the default path template renders
+func getWorktreeName(path string) (string, bool) {
+	base := filepath.Base(path)
+
+	type worktreeKind struct {
+		prefix string
+		suffix string
+		name   func(slug string) (string, bool)
+	}
+	kinds := []*worktreeKind{{
+		prefix: config.Branches.Prefix,
+		suffix: config.Branches.Suffix,
+		name: func(slug string) (string, bool) {
+			return decodeSlug(slug, config.Branches.Slash)
+		},
+	}}
+	if tagsEnabled() {
+		kinds = append(kinds, &worktreeKind{
+			prefix: config.Tags.Prefix,
+			suffix: config.Tags.Suffix,
+			name: func(slug string) (string, bool) {
+				name, ok := decodeSlug(slug, config.Tags.Slash)
+				return "tag " + name, ok
+			},
+		})
+	}
+	if config.MergeRequests.Enabled {
+		kinds = append(kinds, &worktreeKind{
+			prefix: config.MergeRequests.Prefix,
+			name: func(slug string) (string, bool) {
+				_, err := strconv.Atoi(slug)
+				return "mr " + slug, err == nil
+			},
+		})
+	}
+
+	// The longest matched prefix and suffix wins, merge request prefix may start with the branch one
+	var name string
+	var matched int
+	for _, kind := range kinds {
+		length := len(kind.prefix) + len(kind.suffix)
+		if length < matched || len(base) <= length || !strings.HasPrefix(base, kind.prefix) || !strings.HasSuffix(base, kind.suffix) {
+			continue
+		}
+		if kindName, ok := kind.name(strings.TrimSuffix(strings.TrimPrefix(base, kind.prefix), kind.suffix)); ok {
+			name, matched = kindName, length
+		}
+	}
+	return name, name != ""
+}
@@ -0,0 +1,132 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package clone
+
+import "testing"
+
+func TestSlugStrictRoundTrip(t *testing.T) {
+	setTestConfig(t, &ConfigStruct{SlugEncoding: SlugStrict})
+
+	names := []string{
+		"main",
+		"feature/login",
+		"feature/a/b",
+		"feature--login",
+		"100%",
+		"fix/%2F",
+		`win:dir\name*?"<>|`,
+		"tab\tname",
+		"release-1.0",
+		"a-/b",
+		"a/-b",
+		"a_/_b",
+		"my_branch",
+		"x---y",
+	}
+	tests := []struct {
+		slash string
+		slugs map[string]string
+	}{
+		{"--", map[string]string{"feature/login": "feature--login", "feature--login": "feature%2D-login", "100%": "100%25", "a-/b": "a%2D--b", "release-1.0": "release-1.0"}},
+		{"__", map[string]string{"feature/a/b": "feature__a__b", "my_branch": "my_branch", "a_/_b": "a%5F___b"}},
+		{"-", map[string]string{"feature/a/b": "feature-a-b", "release-1.0": "release%2D1.0"}},
+		{"", map[string]string{"feature/login": "feature%2Flogin", "fix/%2F": "fix%2F%252F"}},
+	}
+	for _, test := range tests {
+		for _, name := range names {
+			slug := encodeSlug(name, test.slash)
+			if want, ok := test.slugs[name]; ok && slug != want {
+				t.Errorf("encodeSlug(%q, %q) = %q, want %q", name, test.slash, slug, want)
+			}
+			decoded, ok := decodeSlug(slug, test.slash)
+			if !ok || decoded != name {
+				t.Errorf("decodeSlug(%q, %q) = %q, %v, want %q", slug, test.slash, decoded, ok, name)
+			}
+		}
+	}
+}
+
+func TestSlugLenientCollision(t *testing.T) {
+	setTestConfig(t, &ConfigStruct{SlugEncoding: SlugLenient})
+
+	if a, b := encodeSlug("feature/login", "--"), encodeSlug("feature--login", "--"); a != b {
+		t.Errorf("lenient slugs %q and %q differ, collision is expected", a, b)
+	}
+	if slug := encodeSlug("feature/login", ""); slug != "featurelogin" {
+		t.Errorf("lenient slug with empty slash is %q, want slash dropped", slug)
+	}
+
+	// Ambiguous lenient slugs aren't taken for names they may be encoded from
+	tests := []struct {
+		slug  string
+		slash string
+		name  string
+		ok    bool
+	}{
+		{"a__b", "__", "", false},
+		{"featurelogin", "", "", false},
+		{"my_branch", "__", "my_branch", true},
+		{"100%25", "__", "100%", true},
+	}
+	for _, test := range tests {
+		if name, ok := decodeSlug(test.slug, test.slash); name != test.name || ok != test.ok {
+			t.Errorf("decodeSlug(%q, %q) = %q, %v, want %q, %v", test.slug, test.slash, name, ok, test.name, test.ok)
+		}
+	}
+}
+
+func TestSlugDefaultLenient(t *testing.T) {
+	// Unset encoding keeps paths of existing checkouts
+	setTestConfig(t, &ConfigStruct{})
+
+	if slug := encodeSlug("feature/x", ""); slug != "featurex" {
+		t.Errorf("default slug of feature/x is %q, want featurex", slug)
+	}
+	if slug := encodeSlug("a__b", "__"); slug != "a__b" {
+		t.Errorf("default slug of a__b is %q, want a__b", slug)
+	}
+}
+
+func TestGetWorktreeName(t *testing.T) {
+	configPtr := &ConfigStruct{ExpandBranches: true, SlugEncoding: SlugStrict}
+	configPtr.Branches.Slash = "__"
+	configPtr.Tags.Prefix = "tag-"
+	configPtr.MergeRequests.Enabled = true
+	configPtr.MergeRequests.Prefix = defaultMergeRequestsPrefix
+	setTestConfig(t, configPtr)
+
+	tests := []struct {
+		tags bool
+		path string
+		name string
+	}{
+		{false, "group/project/feature__a%5F_b", "feature/a__b"},
+		{false, "group/project/tag-v1.0", "tag-v1.0"},
+		{true, "group/project/tag-v1.0", "tag v1.0"},
+		{false, "group/project/" + defaultMergeRequestsPrefix + "7", "mr 7"},
+	}
+	for _, test := range tests {
+		configPtr.Tags.Clone = nil
+		if test.tags {
+			configPtr.Tags.Clone = []string{".*"}
+		}
+		if name, _ := getWorktreeName(test.path); name != test.name {
+			t.Errorf("getWorktreeName(%q) with tags %v = %q, want %q", test.path, test.tags, name, test.name)
+		}
+	}
+}
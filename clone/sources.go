@@ -0,0 +1,197 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package clone
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/Logunov/heydevops/helpers"
+	"github.com/Logunov/heydevops/provider"
+	"github.com/sirupsen/logrus"
+)
+
+// SourceStruct is a hosting synced into Subdir, empty fields are taken from the top level config,
+// Filters, Repos and Branches replace top level ones as a whole when set
+type SourceStruct struct {
+	Name         string
+	Provider     string
+	GitLabURL    string
+	GitLabAPIURL string
+	Token        Secret
+	Groups       []string
+	Subdir       string
+	Filters      *provider.Filters
+	Repos        *ReposStruct
+	Branches     *BranchesStruct
+}
+
+// cloneSources syncs selected sources one by one, each of them in its subdirectory
+func cloneSources() {
+	baseConfig := config
+	defer func() {
+		config = baseConfig
+		compileRegexps()
+	}()
+
+	workDir, err := os.Getwd()
+	if err != nil {
+		report.fail("", "", "", err)
+		return
+	}
+
+	for _, source := range selectedSources() {
+		log.WithFields(logrus.Fields{
+			"source": source.Name,
+			"subdir": source.Subdir,
+		}).Info("source sync started")
+
+		config = sourceConfig(baseConfig, source)
+		compileRegexps()
+
+		resultsStart, projectsStart := len(report.Results), len(report.Plan.Projects)
+		switch err := enterSubdir(source.Subdir); {
+		case errors.Is(err, errNoSubdir):
+			report.skip("", "", ".", err.Error())
+		case err != nil:
+			report.fail("", "", ".", fmt.Errorf("source %s: %w", source.Name, err))
+		default:
+			cloneSource()
+		}
+		if err := os.Chdir(workDir); err != nil {
+			log.Fatal("Can't return to working directory: ", err)
+		}
+
+		// Paths are relative to the source subdir while it is synced
+		for _, result := range report.Results[resultsStart:] {
+			if result.Path != "" {
+				result.Path = filepath.Join(source.Subdir, result.Path)
+			}
+		}
+		for _, projectPlan := range report.Plan.Projects[projectsStart:] {
+			projectPlan.Source = source.Name
+		}
+	}
+}
+
+// selectedSources returns sources picked by names, all of them when no names are given
+func selectedSources() []*SourceStruct {
+	if len(config.SelectedSources) == 0 {
+		return config.Sources
+	}
+	var sources []*SourceStruct
+	for _, source := range config.Sources {
+		if containsFold(config.SelectedSources, source.Name) {
+			sources = append(sources, source)
+		}
+	}
+	return sources
+}
+
+// sourceConfig returns copy of the config with settings of the source,
+// addresses and token of the top level config are taken only by sources of the same hosting
+func sourceConfig(baseConfig *ConfigStruct, source *SourceStruct) *ConfigStruct {
+	copied := *baseConfig
+	if source.Provider != "" {
+		copied.Provider = source.Provider
+	}
+	if source.GitLabURL != "" || !sameProvider(baseConfig, source) {
+		copied.GitLabURL = source.GitLabURL
+		// API address of another hosting doesn't fit the source
+		copied.GitLabAPIURL = source.GitLabAPIURL
+	}
+	if source.Token != "" || !inheritsToken(baseConfig, source) {
+		copied.Token = source.Token
+	}
+	if source.Groups != nil {
+		copied.Groups = source.Groups
+	}
+	if source.Filters != nil {
+		copied.Filters = *source.Filters
+	}
+	if source.Repos != nil {
+		copied.Repos = *source.Repos
+	}
+	if source.Branches != nil {
+		copied.Branches = *source.Branches
+	}
+	setDefaultURL(&copied)
+	addSlashIfEndWithOutSlash(&copied.GitLabURL)
+	addSlashIfEndWithOutSlash(&copied.GitLabAPIURL)
+	return &copied
+}
+
+func sameProvider(baseConfig *ConfigStruct, source *SourceStruct) bool {
+	return source.Provider == "" || strings.EqualFold(providerKind(source.Provider), providerKind(baseConfig.Provider))
+}
+
+// inheritsToken reports whether the source takes the top level token, it is sent to the same hosting only
+func inheritsToken(baseConfig *ConfigStruct, source *SourceStruct) bool {
+	if !sameProvider(baseConfig, source) {
+		return false
+	}
+	if source.GitLabURL == "" {
+		return true
+	}
+	sourceURL, err := url.Parse(source.GitLabURL)
+	if err != nil {
+		return false
+	}
+	baseURL, err := url.Parse(baseConfig.GitLabURL)
+	return err == nil && strings.EqualFold(sourceURL.Host, baseURL.Host)
+}
+
+// tokenNotInheritedReason explains why the source doesn't take the top level token
+func tokenNotInheritedReason(baseConfig *ConfigStruct, source *SourceStruct) string {
+	if sameProvider(baseConfig, source) && baseConfig.GitLabURL == "" {
+		return "top level token isn't passed to sources of other hosts without top level gitlab-url"
+	}
+	return "top level token is for another hosting"
+}
+
+// providerKind returns provider name, GitLab is used when it is empty
+func providerKind(name string) string {
+	if name == "" {
+		return provider.GitLab
+	}
+	return strings.ToLower(name)
+}
+
+var errNoSubdir = errors.New("subdir doesn't exist yet, nothing to show in dry run")
+
+// enterSubdir makes the subdir current directory creating it if needed,
+// submodules layout needs its own git repository there
+func enterSubdir(subdir string) error {
+	if subdir == "" {
+		return nil
+	}
+	if config.DryRun && !pathExists(subdir) {
+		return errNoSubdir
+	}
+	if err := os.MkdirAll(subdir, 0o755); err != nil {
+		return err
+	}
+	if (config.Layout == LayoutSubmodules || config.Layout == "") && !pathExists(filepath.Join(subdir, ".git")) {
+		return fmt.Errorf("%s is not a git repository, run git init there or use clones layout", subdir)
+	}
+	return os.Chdir(subdir)
+}
@@ -0,0 +1,71 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package clone
+
+import (
+	"testing"
+
+	. "github.com/Logunov/heydevops/helpers"
+	"github.com/Logunov/heydevops/provider"
+)
+
+func TestSourceConfig(t *testing.T) {
+	baseConfig := &ConfigStruct{
+		Provider:     provider.GitLab,
+		GitLabURL:    "https://gitlab.corp/",
+		GitLabAPIURL: "https://gitlab.corp/api/",
+		Token:        "base-token",
+		Groups:       []string{"infra"},
+	}
+
+	tests := []struct {
+		name   string
+		source *SourceStruct
+		url    string
+		apiURL string
+		token  Secret
+		groups []string
+	}{
+		{"inherits everything", &SourceStruct{Name: "a"}, "https://gitlab.corp/", "https://gitlab.corp/api/", "base-token", []string{"infra"}},
+		{"same host", &SourceStruct{Name: "b", GitLabURL: "https://GitLab.corp/other", Groups: []string{"apps"}},
+			"https://GitLab.corp/other/", "", "base-token", []string{"apps"}},
+		{"same provider named", &SourceStruct{Name: "c", Provider: "GitLab"}, "https://gitlab.corp/", "https://gitlab.corp/api/", "base-token", []string{"infra"}},
+		{"another host", &SourceStruct{Name: "d", GitLabURL: "https://gitlab.com"}, "https://gitlab.com/", "", "", []string{"infra"}},
+		{"another host own token", &SourceStruct{Name: "e", GitLabURL: "https://gitlab.com", Token: "own"}, "https://gitlab.com/", "", "own", []string{"infra"}},
+		{"another provider", &SourceStruct{Name: "f", Provider: provider.GitHub}, provider.GitHubURL, "", "", []string{"infra"}},
+		{"another provider address", &SourceStruct{Name: "g", Provider: provider.Gitea, GitLabURL: "https://gitea.corp"}, "https://gitea.corp/", "", "", []string{"infra"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			copied := sourceConfig(baseConfig, test.source)
+			if copied.GitLabURL != test.url || copied.GitLabAPIURL != test.apiURL {
+				t.Errorf("addresses %q, %q, want %q, %q", copied.GitLabURL, copied.GitLabAPIURL, test.url, test.apiURL)
+			}
+			// Top level token must never be sent to another hosting
+			if copied.Token.Reveal() != test.token.Reveal() {
+				t.Errorf("token %q, want %q", copied.Token.Reveal(), test.token.Reveal())
+			}
+			if len(copied.Groups) != len(test.groups) || copied.Groups[0] != test.groups[0] {
+				t.Errorf("groups %v, want %v", copied.Groups, test.groups)
+			}
+		})
+	}
+	if baseConfig.Token != "base-token" || baseConfig.GitLabURL != "https://gitlab.corp/" {
+		t.Error("top level config is changed by sources")
+	}
+}
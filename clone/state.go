@@ -0,0 +1,261 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package clone
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Logunov/heydevops/provider"
+)
+
+// activityMargin covers GitLab updating last_activity_at lazily, at most once per hour
+const activityMargin = time.Hour
+
+// State is persisted between runs to sync only projects and branches changed upstream
+type State struct {
+	LastRun *time.Time `json:"last_run,omitempty"`
+	// Selection is a hash of settings selecting projects and placing them locally,
+	// all projects are listed when it changes
+	Selection string                   `json:"selection,omitempty"`
+	Projects  map[string]*ProjectState `json:"projects"`
+	// seen are projects synced or found unchanged during the run
+	seen map[string]bool
+}
+
+type ProjectState struct {
+	LastActivityAt *time.Time        `json:"last_activity_at,omitempty"`
+	Branches       map[string]string `json:"branches,omitempty"`
+	// Paths are local paths of the project, all projects are listed when any of them is missing
+	Paths []string `json:"paths,omitempty"`
+}
+
+// selectionSettings are settings which change what projects are synced or where they go
+type selectionSettings struct {
+	Provider       string
+	GitLabURL      string
+	RootRemove     string
+	Groups         []string
+	Filters        provider.Filters
+	Repos          ReposStruct
+	Branches       BranchesStruct
+	Tags           TagsStruct
+	MergeRequests  MergeRequestsStruct
+	Layout         string
+	MirrorWikis    bool
+	ExpandBranches bool
+	PathTemplate   string
+	SlugEncoding   string
+}
+
+// selectionHash returns hash of the config settings selecting projects and placing them locally
+func selectionHash() string {
+	// Settings are plain values, encoding them doesn't fail
+	data, _ := json.Marshal(&selectionSettings{
+		Provider:       config.Provider,
+		GitLabURL:      config.GitLabURL,
+		RootRemove:     config.RootRemove,
+		Groups:         config.Groups,
+		Filters:        config.Filters,
+		Repos:          config.Repos,
+		Branches:       config.Branches,
+		Tags:           config.Tags,
+		MergeRequests:  config.MergeRequests,
+		Layout:         config.Layout,
+		MirrorWikis:    config.MirrorWikis,
+		ExpandBranches: config.ExpandBranches,
+		PathTemplate:   config.PathTemplate,
+		SlugEncoding:   config.SlugEncoding,
+	})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+var stateMutex sync.Mutex
+
+// loadState reads state file, missing file means the first run
+func loadState(path string) (*State, error) {
+	loaded := &State{Projects: make(map[string]*ProjectState)}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return loaded, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, loaded); err != nil {
+		return nil, err
+	}
+	if loaded.Projects == nil {
+		loaded.Projects = make(map[string]*ProjectState)
+	}
+	return loaded, nil
+}
+
+func (s *State) save(path string) error {
+	stateMutex.Lock()
+	data, err := json.MarshalIndent(s, "", "  ")
+	stateMutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	// Write to temporary file first so interrupted run doesn't leave broken state
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// lastActivityAfter returns time projects should be active after to be listed, it is nil when
+// all projects must be listed and the reason is returned then
+func (s *State) lastActivityAfter(selection string) (*time.Time, string) {
+	switch {
+	case s.LastRun == nil:
+		return nil, "no successful run yet"
+	case s.Selection != selection:
+		return nil, "settings selecting projects changed"
+	}
+	for repoPath, projectState := range s.Projects {
+		for _, path := range projectState.Paths {
+			if !pathExists(path) {
+				return nil, "path " + path + " of " + repoPath + " is missing"
+			}
+		}
+	}
+	after := s.LastRun.Add(-activityMargin)
+	return &after, ""
+}
+
+// finishRun remembers successful run, projects of the state which weren't seen during the run
+// listing all projects are deleted upstream or aren't selected any more
+func (s *State) finishRun(runStart time.Time, selection string, listedAll bool) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	s.LastRun, s.Selection = &runStart, selection
+	if !listedAll {
+		return
+	}
+	for repoPath := range s.Projects {
+		if !s.seen[repoPath] {
+			delete(s.Projects, repoPath)
+		}
+	}
+}
+
+func (s *State) project(repoPath string) *ProjectState {
+	projectState, ok := s.Projects[repoPath]
+	if !ok {
+		projectState = &ProjectState{Branches: make(map[string]string)}
+		s.Projects[repoPath] = projectState
+	}
+	if projectState.Branches == nil {
+		projectState.Branches = make(map[string]string)
+	}
+	return projectState
+}
+
+// projectUnchanged reports whether project had no activity since it was synced last time
+func (s *State) projectUnchanged(repoPath string, lastActivityAt *time.Time) bool {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	projectState, ok := s.Projects[repoPath]
+	if !ok || projectState.LastActivityAt == nil || lastActivityAt == nil {
+		return false
+	}
+	return !lastActivityAt.After(*projectState.LastActivityAt)
+}
+
+// branchUnchanged reports whether branch head is the same as it was synced last time
+func (s *State) branchUnchanged(repoPath string, branch string, commitID string) bool {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	projectState, ok := s.Projects[repoPath]
+	if !ok || commitID == "" {
+		return false
+	}
+	return projectState.Branches[branch] == commitID
+}
+
+// startProject forgets local paths of the project synced again, they are remembered while it is synced
+func (s *State) startProject(repoPath string) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	s.project(repoPath).Paths = nil
+	s.markSeen(repoPath)
+}
+
+// keepProject keeps state of the project found unchanged
+func (s *State) keepProject(repoPath string) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	s.markSeen(repoPath)
+}
+
+func (s *State) markSeen(repoPath string) {
+	if s.seen == nil {
+		s.seen = make(map[string]bool)
+	}
+	s.seen[repoPath] = true
+}
+
+// rememberPath remembers local path of the project if it exists
+func (s *State) rememberPath(repoPath string, path string) {
+	if !pathExists(path) {
+		return
+	}
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	projectState := s.project(repoPath)
+	for _, remembered := range projectState.Paths {
+		if remembered == path {
+			return
+		}
+	}
+	projectState.Paths = append(projectState.Paths, path)
+}
+
+func (s *State) rememberProject(repoPath string, lastActivityAt *time.Time) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	s.project(repoPath).LastActivityAt = lastActivityAt
+}
+
+func (s *State) rememberBranch(repoPath string, branch string, commitID string) {
+	if commitID == "" {
+		return
+	}
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	s.project(repoPath).Branches[branch] = commitID
+}
@@ -0,0 +1,240 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package clone
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Logunov/heydevops/provider"
+)
+
+// testHosting returns branches it was set up with, other calls aren't used by tests
+type testHosting struct {
+	provider.Provider
+	branches []*provider.Branch
+	err      error
+}
+
+func (h *testHosting) ListBranches(project *provider.Project) ([]*provider.Branch, error) {
+	return h.branches, h.err
+}
+
+func (h *testHosting) GetBranch(project *provider.Project, name string) (*provider.Branch, error) {
+	if h.err != nil {
+		return nil, h.err
+	}
+	for _, branch := range h.branches {
+		if branch.Name == name {
+			return branch, nil
+		}
+	}
+	return nil, errors.New("404 branch not found")
+}
+
+func TestStateRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "state.json")
+
+	loaded, err := loadState(path)
+	if err != nil {
+		t.Fatalf("missing state file: %v", err)
+	}
+	if after, _ := loaded.lastActivityAfter("selection"); loaded.LastRun != nil || after != nil {
+		t.Error("first run must list all projects")
+	}
+
+	activity := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	lastRun := activity.Add(24 * time.Hour)
+	loaded.finishRun(lastRun, "selection", true)
+	loaded.rememberProject("group/project", &activity)
+	loaded.rememberBranch("group/project", "main", "abc")
+	loaded.rememberBranch("group/project", "develop", "")
+	if err := loaded.save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := loadState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after, reason := saved.lastActivityAfter("selection"); after == nil || !after.Equal(lastRun.Add(-activityMargin)) {
+		t.Errorf("lastActivityAfter() = %v, %q, want %v", after, reason, lastRun.Add(-activityMargin))
+	}
+
+	later := activity.Add(time.Minute)
+	projectTests := []struct {
+		repo     string
+		activity *time.Time
+		want     bool
+	}{
+		{"group/project", &activity, true},
+		{"group/project", &later, false},
+		{"group/project", nil, false},
+		{"group/other", &activity, false},
+	}
+	for _, test := range projectTests {
+		if got := saved.projectUnchanged(test.repo, test.activity); got != test.want {
+			t.Errorf("projectUnchanged(%s, %v) = %v, want %v", test.repo, test.activity, got, test.want)
+		}
+	}
+
+	branchTests := []struct {
+		branch   string
+		commitID string
+		want     bool
+	}{
+		{"main", "abc", true},
+		{"main", "def", false},
+		{"main", "", false},
+		{"develop", "", false},
+		{"feature", "abc", false},
+	}
+	for _, test := range branchTests {
+		if got := saved.branchUnchanged("group/project", test.branch, test.commitID); got != test.want {
+			t.Errorf("branchUnchanged(%s, %q) = %v, want %v", test.branch, test.commitID, got, test.want)
+		}
+	}
+}
+
+func TestLastActivityAfter(t *testing.T) {
+	chdirTemp(t)
+	initClone(t, "group/kept")
+	initClone(t, "group/other")
+	lastRun := time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		selection string
+		remove    string
+		reason    string
+	}{
+		{"unchanged", "selection", "", ""},
+		{"selection changed", "other selection", "", "settings selecting projects changed"},
+		{"path missing", "selection", "group/other", "path group/other of group/other is missing"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state := &State{Projects: make(map[string]*ProjectState)}
+			for _, repoPath := range []string{"group/kept", "group/other"} {
+				state.startProject(repoPath)
+				state.rememberPath(repoPath, repoPath)
+			}
+			// Paths which don't exist aren't remembered
+			state.rememberPath("group/kept", "group/kept/missing")
+			state.finishRun(lastRun, "selection", true)
+			if test.remove != "" {
+				if err := os.Rename(test.remove, test.remove+".moved"); err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() {
+					_ = os.Rename(test.remove+".moved", test.remove)
+				})
+			}
+
+			after, reason := state.lastActivityAfter(test.selection)
+			if reason != test.reason || (after == nil) != (reason != "") {
+				t.Errorf("lastActivityAfter() = %v, %q, want reason %q", after, reason, test.reason)
+			}
+		})
+	}
+}
+
+func TestFinishRun(t *testing.T) {
+	lastRun := time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		listedAll bool
+		kept      []string
+	}{
+		// Projects not listed by incremental run may be just inactive
+		{"listed active", false, []string{"group/gone", "group/synced", "group/unchanged"}},
+		{"listed all", true, []string{"group/synced", "group/unchanged"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state := &State{Projects: map[string]*ProjectState{"group/gone": {}, "group/unchanged": {}}}
+			state.startProject("group/synced")
+			state.keepProject("group/unchanged")
+			state.finishRun(lastRun, "selection", test.listedAll)
+
+			var kept []string
+			for repoPath := range state.Projects {
+				kept = append(kept, repoPath)
+			}
+			sort.Strings(kept)
+			if strings.Join(kept, " ") != strings.Join(test.kept, " ") {
+				t.Errorf("projects %v, want %v", kept, test.kept)
+			}
+			if state.Selection != "selection" || !state.LastRun.Equal(lastRun) {
+				t.Errorf("run isn't remembered: %v %q", state.LastRun, state.Selection)
+			}
+		})
+	}
+}
+
+func TestSelectionHash(t *testing.T) {
+	configPtr := &ConfigStruct{Groups: []string{"group"}}
+	setTestConfig(t, configPtr)
+	hash := selectionHash()
+
+	configPtr.Repos.Clone = []string{"^group/new"}
+	if selectionHash() == hash {
+		t.Error("hash is the same after repos.clone changed")
+	}
+	configPtr.Repos.Clone = nil
+	configPtr.CloneThreadsCount = 8
+	if selectionHash() != hash {
+		t.Error("hash changed with setting which doesn't select projects")
+	}
+}
+
+func TestDefaultBranchCommit(t *testing.T) {
+	savedHosting := hosting
+	t.Cleanup(func() {
+		hosting = savedHosting
+	})
+
+	branches := []*provider.Branch{{Name: "develop", CommitID: "aaa"}, {Name: "main", Default: true, CommitID: "bbb"}}
+	tests := []struct {
+		name          string
+		incremental   bool
+		defaultBranch string
+		err           error
+		want          string
+	}{
+		{"full sync", false, "main", nil, ""},
+		{"incremental", true, "main", nil, "bbb"},
+		{"empty repo", true, "", nil, ""},
+		{"request failed", true, "main", errors.New("boom"), ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setTestConfig(t, &ConfigStruct{Incremental: test.incremental})
+			hosting = &testHosting{branches: branches, err: test.err}
+			project := &provider.Project{DefaultBranch: test.defaultBranch}
+			if got := defaultBranchCommit("group/project", project); got != test.want {
+				t.Errorf("defaultBranchCommit() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package clone
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// PathStatus is a health of a single cloned branch path
+type PathStatus struct {
+	Repo   string `json:"repo" yaml:"repo"`
+	Path   string `json:"path" yaml:"path"`
+	Branch string `json:"branch,omitempty" yaml:"branch,omitempty"`
+	// Worktree is the branch, tag or merge request the path was named after, it is decoded from the directory name
+	Worktree  string `json:"worktree,omitempty" yaml:"worktree,omitempty"`
+	Detached  bool   `json:"detached" yaml:"detached"`
+	Upstream  string `json:"upstream,omitempty" yaml:"upstream,omitempty"`
+	Ahead     int    `json:"ahead" yaml:"ahead"`
+	Behind    int    `json:"behind" yaml:"behind"`
+	Changed   int    `json:"changed" yaml:"changed"`
+	Untracked int    `json:"untracked" yaml:"untracked"`
+	Stale     bool   `json:"stale" yaml:"stale"`
+	Error     string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// Status walks the local layout of every source and collects status of every cloned path,
+// paths of sources are prefixed with their subdirs
+func Status() ([]*PathStatus, error) {
+	statuses := []*PathStatus{}
+	err := walkSources(func(subdir string) error {
+		sourceStatuses, err := statusLocalRepos()
+		if err != nil {
+			return err
+		}
+		for _, status := range sourceStatuses {
+			status.Path = filepath.Join(subdir, status.Path)
+		}
+		statuses = append(statuses, sourceStatuses...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Path < statuses[j].Path
+	})
+	return statuses, nil
+}
+
+// statusLocalRepos collects status of every path of the local layout in the current directory in parallel
+func statusLocalRepos() ([]*PathStatus, error) {
+	repos, err := findLocalRepos()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := []*PathStatus{}
+	var statusesMutex sync.Mutex
+	addStatus := func(status *PathStatus) {
+		statusesMutex.Lock()
+		defer statusesMutex.Unlock()
+		statuses = append(statuses, status)
+	}
+
+	pathsChan := make(chan *PathStatus, config.CloneThreadsCount)
+	var statusWaitGroup sync.WaitGroup
+	for i := 0; i < config.CloneThreadsCount; i++ {
+		statusWaitGroup.Add(1)
+		go func() {
+			defer statusWaitGroup.Done()
+			for status := range pathsChan {
+				readPathStatus(status)
+				addStatus(status)
+			}
+		}()
+	}
+
+	for _, repo := range repos {
+		pathsChan <- newPathStatus(repo.RepoPath, repo.MainPath)
+
+		entries, err := listWorktreeEntries(repo.MainPath)
+		if err != nil {
+			addStatus(&PathStatus{Repo: repo.RepoPath, Path: repo.MainPath, Error: err.Error()})
+			continue
+		}
+		for _, entry := range entries {
+			status := newPathStatus(repo.RepoPath, entry.Path)
+			if entry.Prunable {
+				status.Branch, status.Stale = entry.Branch, true
+				addStatus(status)
+				continue
+			}
+			pathsChan <- status
+		}
+	}
+	close(pathsChan)
+	statusWaitGroup.Wait()
+	return statuses, nil
+}
+
+func newPathStatus(repoPath string, path string) *PathStatus {
+	status := &PathStatus{Repo: repoPath, Path: path}
+	if config.ExpandBranches {
+		status.Worktree, _ = getWorktreeName(path)
+	}
+	return status
+}
+
+// readPathStatus parses git status --porcelain=v2 --branch output
+func readPathStatus(status *PathStatus) {
+	output, err := runOutput(status.Path, "git", "status", "--porcelain=v2", "--branch")
+	if err != nil {
+		status.Error = err.Error()
+		return
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "# branch.head "):
+			status.Branch = strings.TrimPrefix(line, "# branch.head ")
+			if status.Branch == "(detached)" {
+				status.Branch = ""
+				status.Detached = true
+			}
+		case strings.HasPrefix(line, "# branch.upstream "):
+			status.Upstream = strings.TrimPrefix(line, "# branch.upstream ")
+		case strings.HasPrefix(line, "# branch.ab "):
+			fields := strings.Fields(strings.TrimPrefix(line, "# branch.ab "))
+			if len(fields) == 2 {
+				status.Ahead, _ = strconv.Atoi(strings.TrimPrefix(fields[0], "+"))
+				status.Behind, _ = strconv.Atoi(strings.TrimPrefix(fields[1], "-"))
+			}
+		case strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "? "):
+			status.Untracked++
+		default:
+			status.Changed++
+		}
+	}
+}
+
+// PrintStatus writes statuses as a table
+func PrintStatus(writer io.Writer, statuses []*PathStatus) {
+	tabWriter := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tabWriter, "PATH\tBRANCH\tUPSTREAM\tAHEAD\tBEHIND\tCHANGED\tUNTRACKED\tSTATE")
+	for _, status := range statuses {
+		// Detached worktrees are shown with the tag or merge request they were named after
+		branch := status.Branch
+		if branch == "" {
+			branch = status.Worktree
+		}
+		fmt.Fprintf(tabWriter, "%s\t%s\t%s\t%d\t%d\t%d\t%d\t%s\n", status.Path, branch, status.Upstream,
+			status.Ahead, status.Behind, status.Changed, status.Untracked, status.state())
+	}
+	_ = tabWriter.Flush()
+}
+
+func (s *PathStatus) state() string {
+	var states []string
+	if s.Error != "" {
+		states = append(states, "error: "+s.Error)
+	}
+	if s.Stale {
+		states = append(states, "stale worktree")
+	}
+	if s.Detached {
+		states = append(states, "detached")
+	}
+	if s.Upstream == "" && !s.Stale && s.Error == "" && !s.Detached {
+		states = append(states, "no upstream")
+	}
+	if s.Changed > 0 || s.Untracked > 0 {
+		states = append(states, "dirty")
+	}
+	if len(states) == 0 {
+		return "ok"
+	}
+	return strings.Join(states, ", ")
+}
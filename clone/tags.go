@@ -0,0 +1,240 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package clone
+
+import (
+	"errors"
+	"fmt"
+	re "regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Logunov/heydevops/provider"
+	"github.com/sirupsen/logrus"
+)
+
+// TagsStruct sets tag worktrees paths up and selects tags by name regexps,
+// tags are expanded only when clone regexps are set
+type TagsStruct struct {
+	Prefix string
+	Suffix string
+	Slash  string
+	SkipCloneStringsStruct
+	// LatestSemver keeps only that number of the highest semantic version tags
+	LatestSemver int
+}
+
+var semverRegexp = re.MustCompile(`^v?(\d+)\.(\d+)\.(\d+)(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+
+func tagsEnabled() bool {
+	return config.ExpandBranches && len(config.Tags.Clone) > 0
+}
+
+// getTagPath returns path the tag is checked out to
+func getTagPath(repoPath string, tag string) string {
+	path, _ := worktreePath(repoPath, tag, config.Tags.Prefix, getTagSlug(tag), config.Tags.Suffix)
+	return path
+}
+
+func getTagSlug(tag string) string {
+	return encodeSlug(tag, config.Tags.Slash)
+}
+
+// addTags checks tags matched by tags regexps out as detached worktrees of the default branch clone,
+// returns true when all of them were synced successfully
+func addTags(repoPath string, defaultBranch string, tags []*provider.Tag, projectPlan *PlanProject) bool {
+	mainPath := getBranchPath(repoPath, defaultBranch)
+	cloneOptions := getCloneOptions(repoPath)
+	skipReasons := selectTags(tags)
+
+	synced := true
+	for _, tag := range tags {
+		tagPath := getTagPath(repoPath, tag.Name)
+		tagPlan := &PlanBranch{Name: tag.Name, Slug: getTagSlug(tag.Name), Path: tagPath}
+		projectPlan.addTag(tagPlan)
+
+		// Skipped tags are in the plan only, repos may have thousands of them
+		tagPlan.MatchedBy, tagPlan.SkippedBy, tagPlan.Matched = matchSkipCloneRegexps(&tagsSkipCloneRegexList, tag.Name)
+		if !tagPlan.Matched {
+			continue
+		}
+		if reason, skipped := skipReasons[tag.Name]; skipped {
+			tagPlan.SkippedBy, tagPlan.Matched = reason, false
+			continue
+		}
+
+		log.WithFields(logrus.Fields{
+			"repoPath": repoPath,
+			"tag":      tag.Name,
+			"tagPath":  tagPath,
+		}).Debug("tag checkout started")
+
+		outcome, err := syncDetached(newPlanGit(&tagPlan.Actions), mainPath, "refs/tags/"+tag.Name, tag.CommitID, tagPath, cloneOptions)
+		if !reportDetached(repoPath, "tag "+tag.Name, tagPlan, outcome, err) {
+			synced = false
+		}
+	}
+	return synced
+}
+
+// reportDetached records outcome of detached worktree sync, returns true on success
+func reportDetached(repoPath string, result string, plan *PlanBranch, outcome string, err error) bool {
+	state.rememberPath(repoPath, plan.Path)
+	switch {
+	case errors.Is(err, errLocalChanges):
+		plan.SkippedBy = err.Error()
+		report.skip(repoPath, result, plan.Path, err.Error())
+		return false
+	case err != nil:
+		report.fail(repoPath, result, plan.Path, err)
+		return false
+	}
+
+	if note := retriesNote(plan.Actions); note != "" {
+		outcome += ", " + note
+	}
+	report.success(repoPath, result, plan.Path, outcome)
+	return true
+}
+
+// syncDetached adds detached worktree of the ref or checks the ref out again if it was moved
+func syncDetached(git Git, mainPath string, ref string, commitID string, path string, options *CloneOptions) (string, error) {
+	if !pathExists(path) {
+		if err := git.FetchRef(mainPath, ref); err != nil {
+			return "", err
+		}
+		return dryRunOutcome("worktree added"), git.WorktreeAddDetached(mainPath, path, ref, options)
+	}
+
+	if commitID != "" && headCommit(path) == commitID {
+		return "up to date", nil
+	}
+
+	var outcomes []string
+	dirty, err := isDirty(path)
+	if err != nil {
+		return "", err
+	}
+	if dirty {
+		if config.OnDirty != OnDirtyStash {
+			return "", fmt.Errorf("%s %w", path, errLocalChanges)
+		}
+		if err := git.Stash(path); err != nil {
+			return "", err
+		}
+		outcomes = append(outcomes, "local changes stashed")
+	}
+
+	if err := git.FetchRef(path, ref); err != nil {
+		return "", err
+	}
+	if err := git.CheckoutDetached(path, ref); err != nil {
+		return "", err
+	}
+	return strings.Join(append(outcomes, dryRunOutcome("moved, checked out again")), ", "), nil
+}
+
+// selectTags returns reasons tags matched by tags regexps are skipped by latest semver rule
+func selectTags(tags []*provider.Tag) map[string]string {
+	reasons := make(map[string]string)
+	if config.Tags.LatestSemver <= 0 {
+		return reasons
+	}
+
+	type semverTag struct {
+		name    string
+		version []string
+	}
+	var semverTags []*semverTag
+	for _, tag := range tags {
+		if !checkSkipCloneRegexps(&tagsSkipCloneRegexList, tag.Name) {
+			continue
+		}
+		match := semverRegexp.FindStringSubmatch(tag.Name)
+		if match == nil {
+			reasons[tag.Name] = "not a semantic version"
+			continue
+		}
+		semverTags = append(semverTags, &semverTag{name: tag.Name, version: match[1:]})
+	}
+
+	sort.SliceStable(semverTags, func(i, j int) bool {
+		return compareSemver(semverTags[i].version, semverTags[j].version) > 0
+	})
+	for i, tag := range semverTags {
+		if i >= config.Tags.LatestSemver {
+			reasons[tag.name] = fmt.Sprintf("not among %d latest semantic versions", config.Tags.LatestSemver)
+		}
+	}
+	return reasons
+}
+
+// compareSemver compares major, minor, patch and pre-release parts of semantic versions,
+// release is higher than its pre-releases
+func compareSemver(a []string, b []string) int {
+	for i := 0; i < 3; i++ {
+		if result := compareNumbers(a[i], b[i]); result != 0 {
+			return result
+		}
+	}
+
+	switch {
+	case a[3] == b[3]:
+		return 0
+	case a[3] == "":
+		return 1
+	case b[3] == "":
+		return -1
+	}
+
+	aIdentifiers, bIdentifiers := strings.Split(a[3], "."), strings.Split(b[3], ".")
+	for i := 0; i < len(aIdentifiers) && i < len(bIdentifiers); i++ {
+		aNumber, aErr := strconv.Atoi(aIdentifiers[i])
+		bNumber, bErr := strconv.Atoi(bIdentifiers[i])
+		switch {
+		case aErr == nil && bErr == nil:
+			if aNumber != bNumber {
+				return aNumber - bNumber
+			}
+		// Numeric identifiers are lower than alphanumeric ones
+		case aErr == nil:
+			return -1
+		case bErr == nil:
+			return 1
+		default:
+			if result := strings.Compare(aIdentifiers[i], bIdentifiers[i]); result != 0 {
+				return result
+			}
+		}
+	}
+	return len(aIdentifiers) - len(bIdentifiers)
+}
+
+func compareNumbers(a string, b string) int {
+	aNumber, _ := strconv.Atoi(a)
+	bNumber, _ := strconv.Atoi(b)
+	switch {
+	case aNumber < bNumber:
+		return -1
+	case aNumber > bNumber:
+		return 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,117 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package clone
+
+import (
+	"io"
+	re "regexp"
+	"strings"
+	"testing"
+
+	"github.com/Logunov/heydevops/provider"
+	"github.com/sirupsen/logrus"
+)
+
+// setTestConfig sets package config and a silent logger up for the test
+func setTestConfig(t *testing.T, configPtr *ConfigStruct) {
+	t.Helper()
+	savedConfig, savedLog := config, log
+	savedRepos, savedBranches, savedTags := reposSkipCloneRegexList, branchesSkipCloneRegexList, tagsSkipCloneRegexList
+	t.Cleanup(func() {
+		config, log = savedConfig, savedLog
+		reposSkipCloneRegexList, branchesSkipCloneRegexList, tagsSkipCloneRegexList = savedRepos, savedBranches, savedTags
+	})
+
+	config, log = configPtr, logrus.New()
+	log.SetOutput(io.Discard)
+}
+
+func TestCompareSemver(t *testing.T) {
+	tests := []struct {
+		a    string
+		b    string
+		want int
+	}{
+		{"1.2.3", "1.2.3", 0},
+		{"v1.2.3", "1.2.3", 0},
+		{"1.2.3+build.1", "1.2.3+build.2", 0},
+		{"1.10.0", "1.9.0", 1},
+		{"2.0.0", "10.0.0", -1},
+		{"1.2.4", "1.2.3", 1},
+		{"1.0.0", "1.0.0-rc.1", 1},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{"1.0.0-alpha.beta", "1.0.0-beta", -1},
+		{"1.0.0-beta.2", "1.0.0-beta.11", -1},
+		{"1.0.0-rc.1", "1.0.0-beta.11", 1},
+	}
+	for _, test := range tests {
+		a, b := semverRegexp.FindStringSubmatch(test.a), semverRegexp.FindStringSubmatch(test.b)
+		if a == nil || b == nil {
+			t.Fatalf("%s or %s isn't a semantic version", test.a, test.b)
+		}
+		got := compareSemver(a[1:], b[1:])
+		if got > 0 {
+			got = 1
+		} else if got < 0 {
+			got = -1
+		}
+		if got != test.want {
+			t.Errorf("compareSemver(%s, %s) = %d, want %d", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestSelectTags(t *testing.T) {
+	names := []string{"v1.0.0", "v1.2.0", "v1.10.0", "v2.0.0-rc.1", "v2.0.0", "nightly", "old-1.0.0"}
+	var tags []*provider.Tag
+	for _, name := range names {
+		tags = append(tags, &provider.Tag{Name: name})
+	}
+
+	tests := []struct {
+		name   string
+		latest int
+		clone  string
+		kept   []string
+	}{
+		{"disabled", 0, ".*", names},
+		{"latest two with pre-release", 2, ".*", []string{"v2.0.0-rc.1", "v2.0.0"}},
+		{"more than there are", 10, ".*", []string{"v1.0.0", "v1.2.0", "v1.10.0", "v2.0.0-rc.1", "v2.0.0"}},
+		{"of matched only", 1, "^v1", []string{"v1.10.0", "v2.0.0-rc.1", "v2.0.0", "nightly", "old-1.0.0"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			configPtr := &ConfigStruct{}
+			configPtr.Tags.LatestSemver = test.latest
+			setTestConfig(t, configPtr)
+			tagsSkipCloneRegexList = SkipCloneRegexStruct{Clone: []*re.Regexp{re.MustCompile(test.clone)}}
+
+			reasons := selectTags(tags)
+			var kept []string
+			for _, name := range names {
+				if _, skipped := reasons[name]; !skipped {
+					kept = append(kept, name)
+				}
+			}
+			if strings.Join(kept, " ") != strings.Join(test.kept, " ") {
+				t.Errorf("kept %v, want %v, reasons %v", kept, test.kept, reasons)
+			}
+		})
+	}
+}
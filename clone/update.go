@@ -0,0 +1,126 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package clone
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	UpdateFFOnly    = "ff-only"
+	UpdateRebase    = "rebase"
+	UpdateFetchOnly = "fetch-only"
+	UpdateResetHard = "reset-hard"
+
+	OnDirtySkip  = "skip"
+	OnDirtyStash = "stash"
+)
+
+var errLocalChanges = errors.New("has uncommitted changes, not updated")
+
+// checkUpdateConfig sets default update strategy and dirty paths policy and validates them
+func checkUpdateConfig() error {
+	config.UpdateStrategy = strings.ToLower(config.UpdateStrategy)
+	switch config.UpdateStrategy {
+	case "":
+		config.UpdateStrategy = UpdateFFOnly
+	case UpdateFFOnly, UpdateRebase, UpdateFetchOnly, UpdateResetHard:
+	default:
+		return fmt.Errorf("unknown update strategy %q, supported: %s, %s, %s, %s",
+			config.UpdateStrategy, UpdateFFOnly, UpdateRebase, UpdateFetchOnly, UpdateResetHard)
+	}
+
+	config.OnDirty = strings.ToLower(config.OnDirty)
+	switch config.OnDirty {
+	case "":
+		config.OnDirty = OnDirtySkip
+	case OnDirtySkip, OnDirtyStash:
+	default:
+		return fmt.Errorf("unknown dirty paths policy %q, supported: %s, %s", config.OnDirty, OnDirtySkip, OnDirtyStash)
+	}
+	return nil
+}
+
+// updateBranch checks out and updates existing branch path with configured strategy,
+// it returns what was done for the summary
+func updateBranch(git Git, branchPath string, branch string) (string, error) {
+	dirty, err := isDirty(branchPath)
+	if err != nil {
+		return "", err
+	}
+
+	var outcomes []string
+	if dirty {
+		if config.OnDirty != OnDirtyStash {
+			return "", fmt.Errorf("%s %w", branchPath, errLocalChanges)
+		}
+		log.WithFields(logrus.Fields{
+			"branchPath": branchPath,
+		}).Info("stashing local changes")
+		if err := git.Stash(branchPath); err != nil {
+			return "", err
+		}
+		outcomes = append(outcomes, "local changes stashed")
+	}
+
+	if err := git.Checkout(branchPath, branch); err != nil {
+		return "", err
+	}
+
+	headBefore := headCommit(branchPath)
+	if err := git.Pull(branchPath, config.UpdateStrategy); err != nil {
+		return "", err
+	}
+
+	switch {
+	case config.DryRun:
+		outcomes = append(outcomes, config.UpdateStrategy+" (dry run)")
+	case config.UpdateStrategy == UpdateFetchOnly:
+		outcomes = append(outcomes, "fetched")
+	case headBefore == headCommit(branchPath):
+		outcomes = append(outcomes, "up to date")
+	case config.UpdateStrategy == UpdateRebase:
+		outcomes = append(outcomes, "rebased")
+	case config.UpdateStrategy == UpdateResetHard:
+		outcomes = append(outcomes, "reset to upstream")
+	default:
+		outcomes = append(outcomes, "fast-forwarded")
+	}
+	return strings.Join(outcomes, ", "), nil
+}
+
+// isDirty reports whether path has uncommitted changes or untracked files
+func isDirty(path string) (bool, error) {
+	output, err := runOutput(path, "git", "status", "--porcelain")
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(output) != "", nil
+}
+
+func headCommit(path string) string {
+	output, err := runOutput(path, "git", "rev-parse", "HEAD")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(output)
+}
@@ -0,0 +1,119 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package clone
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestUpdateBranch(t *testing.T) {
+	// git commands of the core take identity for commits and stashes from environment
+	t.Setenv("GIT_AUTHOR_NAME", "test")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+
+	tests := []struct {
+		name     string
+		strategy string
+		onDirty  string
+		dryRun   bool
+		// diverged adds local commit, dirty adds local change
+		diverged bool
+		dirty    bool
+		outcome  string
+		err      error
+		// upstream is true when head must be the upstream one after update
+		upstream bool
+		stashed  bool
+	}{
+		{"ff-only", UpdateFFOnly, OnDirtySkip, false, false, false, "fast-forwarded", nil, true, false},
+		{"ff-only diverged", UpdateFFOnly, OnDirtySkip, false, true, false, "", nil, false, false},
+		{"fetch-only", UpdateFetchOnly, OnDirtySkip, false, false, false, "fetched", nil, false, false},
+		{"rebase diverged", UpdateRebase, OnDirtySkip, false, true, false, "rebased", nil, false, false},
+		{"reset-hard diverged", UpdateResetHard, OnDirtySkip, false, true, false, "reset to upstream", nil, true, false},
+		{"reset-hard dirty", UpdateResetHard, OnDirtySkip, false, false, true, "", errLocalChanges, false, false},
+		{"reset-hard dry run", UpdateResetHard, OnDirtySkip, true, true, false, "reset-hard (dry run)", nil, false, false},
+		{"dirty stashed", UpdateFFOnly, OnDirtyStash, false, false, true, "local changes stashed, fast-forwarded", nil, true, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setTestConfig(t, &ConfigStruct{UpdateStrategy: test.strategy, OnDirty: test.onDirty, DryRun: test.dryRun})
+			chdirTemp(t)
+			initClone(t, "upstream")
+			runGit(t, ".", "clone", "--quiet", "upstream", "work")
+			runGit(t, "upstream", "commit", "--quiet", "--allow-empty", "-m", "upstream")
+			upstreamHead := headCommit("upstream")
+			if test.diverged {
+				if err := os.WriteFile("work/local.txt", []byte("local"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				runGit(t, "work", "add", "local.txt")
+				runGit(t, "work", "commit", "--quiet", "-m", "local")
+			}
+			if test.dirty {
+				if err := os.WriteFile("work/dirty.txt", []byte("work in progress"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			headBefore := headCommit("work")
+
+			outcome, err := updateBranch(&binaryGit{}, "work", "main")
+			switch {
+			case test.err != nil:
+				if !errors.Is(err, test.err) {
+					t.Fatalf("err %v, want %v", err, test.err)
+				}
+				if headCommit("work") != headBefore || !pathExists("work/dirty.txt") {
+					t.Error("dirty path was changed")
+				}
+				return
+			case test.outcome == "":
+				if err == nil {
+					t.Fatalf("outcome %q, want error", outcome)
+				}
+				return
+			case err != nil:
+				t.Fatal(err)
+			}
+
+			if outcome != test.outcome {
+				t.Errorf("outcome %q, want %q", outcome, test.outcome)
+			}
+			if head := headCommit("work"); (head == upstreamHead) != test.upstream {
+				t.Errorf("head %s, upstream %s, want upstream %v", head, upstreamHead, test.upstream)
+			}
+			if test.strategy == UpdateResetHard && !test.dryRun && pathExists("work/local.txt") {
+				t.Error("local commit is kept by reset-hard")
+			}
+			if test.dryRun && headCommit("work") != headBefore {
+				t.Error("dry run changed head")
+			}
+			stashes, err := runOutput("work", "git", "stash", "list")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stashed := strings.Contains(stashes, "heydevops"); stashed != test.stashed {
+				t.Errorf("stash list %q, want stashed %v", stashes, test.stashed)
+			}
+		})
+	}
+}
@@ -0,0 +1,386 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package clone
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	re "regexp"
+	"strings"
+
+	. "github.com/Logunov/heydevops/helpers"
+	"github.com/Logunov/heydevops/provider"
+)
+
+// maxPerPage is the largest page size hosting APIs return
+const maxPerPage = 100
+
+var visibilities = []string{"private", "internal", "public"}
+
+// ConfigError is a problem of the config key, File and Line are set when the key comes from config file
+type ConfigError struct {
+	Key     string
+	Message string
+	File    string
+	Line    int
+}
+
+func (e *ConfigError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s: %s", e.File, e.Line, e.Key, e.Message)
+	}
+	return e.Key + ": " + e.Message
+}
+
+type configErrors []*ConfigError
+
+// add appends the error once, sources inheriting top level settings would repeat it
+func (e *configErrors) add(key string, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	for _, configError := range *e {
+		if configError.Key == key && configError.Message == message {
+			return
+		}
+	}
+	*e = append(*e, &ConfigError{Key: key, Message: message})
+}
+
+// Validate checks values of the config, keys of errors are config keys,
+// list items are suffixed with index like repos.clone[1]
+func Validate(configPtr *ConfigStruct) []*ConfigError {
+	var errs configErrors
+
+	kind := strings.ToLower(configPtr.Provider)
+	if !configPtr.Offline {
+		errs.checkOneOf("provider", kind, "", provider.GitLab, provider.GitHub, provider.Gitea)
+		// GitHub has the well-known address, GitLab and Gitea are self-hosted, sources have their own ones
+		errs.checkURL("gitlab-url", configPtr.GitLabURL, kind != provider.GitHub && len(configPtr.Sources) == 0)
+		errs.checkURL("gitlab-api-url", configPtr.GitLabAPIURL, false)
+		errs.checkToken("token", configPtr.Token)
+	}
+
+	errs.checkOneOf("git-backend", configPtr.GitBackend, "", GitBackendBinary, GitBackendGoGit)
+	errs.checkOneOf("layout", configPtr.Layout, "", LayoutSubmodules, LayoutClones, LayoutMirror)
+	errs.checkOneOf("clone-protocol", strings.ToLower(configPtr.CloneProtocol), "", provider.ProtocolSSH, provider.ProtocolHTTPS)
+	errs.checkOneOf("update-strategy", strings.ToLower(configPtr.UpdateStrategy), "", UpdateFFOnly, UpdateRebase, UpdateFetchOnly, UpdateResetHard)
+	errs.checkOneOf("on-dirty", strings.ToLower(configPtr.OnDirty), "", OnDirtySkip, OnDirtyStash)
+	errs.checkOneOf("slug-encoding", configPtr.SlugEncoding, "", SlugLenient, SlugStrict)
+
+	if configPtr.CloneThreadsCount < 1 {
+		errs.add("clone-threads", "must be at least 1, got %d", configPtr.CloneThreadsCount)
+	}
+	if configPtr.ListOptionsPerPage < 1 || configPtr.ListOptionsPerPage > maxPerPage {
+		errs.add("list-options-per-page", "must be from 1 to %d, got %d", maxPerPage, configPtr.ListOptionsPerPage)
+	}
+	if configPtr.Retry.Attempts < 1 {
+		errs.add("retry-attempts", "must be at least 1, got %d", configPtr.Retry.Attempts)
+	}
+	if configPtr.Retry.Delay < 0 {
+		errs.add("retry-delay", "must not be negative, got %s", configPtr.Retry.Delay)
+	}
+	if configPtr.Retry.MaxDelay < configPtr.Retry.Delay {
+		errs.add("retry-max-delay", "must not be less than retry-delay %s, got %s", configPtr.Retry.Delay, configPtr.Retry.MaxDelay)
+	}
+
+	errs.checkFilters("filters.", &configPtr.Filters)
+	errs.checkRepos("repos.", &configPtr.Repos)
+	errs.checkBranches("branches.", &configPtr.Branches)
+	if len(configPtr.Sources) == 0 {
+		errs.checkNamespaceKind("repos.", kind, &configPtr.Repos)
+		errs.checkBranchDates("branches.", kind, &configPtr.Branches)
+	}
+	errs.checkNotNegative("tags.latest-semver", configPtr.Tags.LatestSemver)
+	errs.checkRegexps("tags.clone", configPtr.Tags.Clone)
+	errs.checkRegexps("tags.skip", configPtr.Tags.Skip)
+	errs.checkRegexps("merge-requests.target-branches", configPtr.MergeRequests.TargetBranches)
+
+	errs.checkSlugs(configPtr)
+	errs.checkPathTemplate(configPtr)
+	errs.checkCloneOptions(configPtr.CloneOptions)
+	errs.checkSources(configPtr)
+	return errs
+}
+
+func (e *configErrors) checkFilters(prefix string, filters *provider.Filters) {
+	e.checkOneOf(prefix+"visibility", strings.ToLower(filters.Visibility), append([]string{""}, visibilities...)...)
+}
+
+func (e *configErrors) checkRepos(prefix string, repos *ReposStruct) {
+	for i, visibility := range repos.Visibility {
+		e.checkOneOf(fmt.Sprintf("%svisibility[%d]", prefix, i), strings.ToLower(visibility), visibilities...)
+	}
+	e.checkOneOf(prefix+"namespace-kind", strings.ToLower(repos.NamespaceKind), "", provider.NamespaceUser, provider.NamespaceGroup)
+	e.checkNotNegative(prefix+"active-within-days", repos.ActiveWithinDays)
+	e.checkRegexps(prefix+"clone", repos.Clone)
+	e.checkRegexps(prefix+"skip", repos.Skip)
+}
+
+// checkNamespaceKind rejects namespace kind rule for Gitea, it doesn't tell users from organizations owning repos
+func (e *configErrors) checkNamespaceKind(prefix string, kind string, repos *ReposStruct) {
+	if kind == provider.Gitea && repos.NamespaceKind != "" {
+		e.add(prefix+"namespace-kind", "isn't supported by %s provider, it doesn't return kinds of repo owners", provider.Gitea)
+	}
+}
+
+func (e *configErrors) checkBranches(prefix string, branches *BranchesStruct) {
+	e.checkNotNegative(prefix+"max-age-days", branches.MaxAgeDays)
+	e.checkNotNegative(prefix+"latest", branches.Latest)
+	e.checkRegexps(prefix+"clone", branches.Clone)
+	e.checkRegexps(prefix+"skip", branches.Skip)
+	e.checkSlugPart(prefix+"prefix", branches.Prefix)
+	e.checkSlugPart(prefix+"suffix", branches.Suffix)
+	e.checkSlugPart(prefix+"slash", branches.Slash)
+}
+
+// checkBranchDates rejects rules needing commit dates of branches, GitHub doesn't return them with branches
+func (e *configErrors) checkBranchDates(prefix string, kind string, branches *BranchesStruct) {
+	if kind != provider.GitHub {
+		return
+	}
+	if branches.MaxAgeDays > 0 {
+		e.add(prefix+"max-age-days", "isn't supported by %s provider, it doesn't return commit dates of branches", provider.GitHub)
+	}
+	if branches.Latest > 0 {
+		e.add(prefix+"latest", "isn't supported by %s provider, it doesn't return commit dates of branches", provider.GitHub)
+	}
+}
+
+func (e *configErrors) checkCloneOptions(rules []*CloneOptionsStruct) {
+	for i, rule := range rules {
+		prefix := fmt.Sprintf("clone-options[%d].", i)
+		if len(rule.Repos) == 0 {
+			e.add(prefix+"repos", "is required, rule without repos regexps matches nothing")
+		}
+		e.checkRegexps(prefix+"repos", rule.Repos)
+		if rule.Filter != "" && !cloneFilterRegexp.MatchString(rule.Filter) {
+			e.add(prefix+"filter", "unknown filter %q, supported: blob:none, blob:limit=<size>, tree:<depth>", rule.Filter)
+		}
+		e.checkNotNegative(prefix+"depth", rule.Depth)
+		for j, pattern := range rule.Sparse {
+			// Cone mode takes directories only
+			if !filepath.IsLocal(pattern) || strings.ContainsAny(pattern, "*?[!\\") {
+				e.add(fmt.Sprintf("%ssparse[%d]", prefix, j), "must be a directory relative to the repo root without wildcards, got %q", pattern)
+			}
+		}
+	}
+}
+
+// checkSources checks every source with settings it takes from the top level config
+func (e *configErrors) checkSources(configPtr *ConfigStruct) {
+	names := make(map[string]bool)
+	for i, source := range configPtr.Sources {
+		prefix := fmt.Sprintf("sources[%d].", i)
+		sourceConfigPtr := sourceConfig(configPtr, source)
+
+		if source.Name == "" {
+			e.add(prefix+"name", "is required")
+		} else if names[strings.ToLower(source.Name)] {
+			e.add(prefix+"name", "source %q is defined twice", source.Name)
+		}
+		names[strings.ToLower(source.Name)] = true
+
+		kind := strings.ToLower(sourceConfigPtr.Provider)
+		if !configPtr.Offline {
+			e.checkOneOf(prefix+"provider", kind, "", provider.GitLab, provider.GitHub, provider.Gitea)
+			e.checkURL(prefix+"gitlab-url", sourceConfigPtr.GitLabURL, kind != provider.GitHub)
+			e.checkURL(prefix+"gitlab-api-url", source.GitLabAPIURL, false)
+			e.checkToken(prefix+"token", source.Token)
+			if source.Token == "" && !inheritsToken(configPtr, source) {
+				e.add(prefix+"token", "is required, %s", tokenNotInheritedReason(configPtr, source))
+			}
+		}
+
+		if source.Subdir != "" && !filepath.IsLocal(source.Subdir) {
+			e.add(prefix+"subdir", "must be a relative path inside the current directory, got %q", source.Subdir)
+		}
+		// Prune of a source removes everything under its subdir which isn't its project
+		for j, other := range configPtr.Sources[:i] {
+			if pathContains(source.Subdir, other.Subdir) || pathContains(other.Subdir, source.Subdir) {
+				e.add(prefix+"subdir", "overlaps with subdir %q of sources[%d], sources need separate subdirs", other.Subdir, j)
+			}
+		}
+
+		if source.Filters != nil {
+			e.checkFilters(prefix+"filters.", source.Filters)
+		}
+		if source.Repos != nil {
+			e.checkRepos(prefix+"repos.", source.Repos)
+			e.checkNamespaceKind(prefix+"repos.", kind, source.Repos)
+		} else {
+			e.checkNamespaceKind("repos.", kind, &configPtr.Repos)
+		}
+		if source.Branches != nil {
+			e.checkBranches(prefix+"branches.", source.Branches)
+			e.checkBranchDates(prefix+"branches.", kind, source.Branches)
+		} else {
+			e.checkBranchDates("branches.", kind, &configPtr.Branches)
+		}
+	}
+
+	for _, name := range configPtr.SelectedSources {
+		if !names[strings.ToLower(name)] {
+			e.add("source", "unknown source %q", name)
+		}
+	}
+}
+
+// pathContains reports whether path is parent of subpath or the same path, empty path is the current directory
+func pathContains(path string, subpath string) bool {
+	relPath, err := filepath.Rel(filepath.Join(".", path), filepath.Join(".", subpath))
+	return err == nil && filepath.IsLocal(relPath)
+}
+
+func (e *configErrors) checkOneOf(key string, value string, allowed ...string) {
+	for _, allowedValue := range allowed {
+		if value == allowedValue {
+			return
+		}
+	}
+	var supported []string
+	for _, allowedValue := range allowed {
+		if allowedValue != "" {
+			supported = append(supported, allowedValue)
+		}
+	}
+	e.add(key, "unknown value %q, supported: %s", value, strings.Join(supported, ", "))
+}
+
+func (e *configErrors) checkNotNegative(key string, value int) {
+	if value < 0 {
+		e.add(key, "must not be negative, got %d", value)
+	}
+}
+
+func (e *configErrors) checkURL(key string, value string, required bool) {
+	if value == "" {
+		if required {
+			e.add(key, "is required")
+		}
+		return
+	}
+	parsedURL, err := url.Parse(value)
+	if err != nil {
+		e.add(key, "invalid URL: %s", err)
+		return
+	}
+	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" || parsedURL.Host == "" {
+		e.add(key, "must be absolute http or https URL, got %q", value)
+	}
+}
+
+// checkToken checks syntax of token reference, it isn't resolved as commands may ask for passphrases
+func (e *configErrors) checkToken(key string, token Secret) {
+	value := token.Reveal()
+	for _, prefix := range []string{TokenCommand, TokenFile, TokenEnv} {
+		if value == prefix {
+			e.add(key, "%s token reference is empty", prefix)
+		}
+	}
+	if credentialURL, found := strings.CutPrefix(value, TokenCredential); found {
+		e.checkURL(key, credentialURL, false)
+	}
+}
+
+func (e *configErrors) checkRegexps(key string, regexps []string) {
+	for i, regexpString := range regexps {
+		if _, err := re.Compile(regexpString); err != nil {
+			e.add(fmt.Sprintf("%s[%d]", key, i), "invalid regexp: %s", err)
+		}
+	}
+}
+
+// checkSlugs checks prefixes, suffixes and slash replacements which make worktree directory names
+func (e *configErrors) checkSlugs(configPtr *ConfigStruct) {
+	e.checkSlugPart("tags.prefix", configPtr.Tags.Prefix)
+	e.checkSlugPart("tags.suffix", configPtr.Tags.Suffix)
+	e.checkSlugPart("tags.slash", configPtr.Tags.Slash)
+	e.checkSlugPart("merge-requests.prefix", configPtr.MergeRequests.Prefix)
+
+	if !configPtr.ExpandBranches {
+		return
+	}
+	// Hidden directories aren't walked by prune of clones layout
+	if strings.HasPrefix(configPtr.Branches.Prefix, ".") {
+		e.add("branches.prefix", "must not start with a dot, worktrees would be hidden")
+	}
+	if len(configPtr.Tags.Clone) > 0 && configPtr.Tags.Prefix == configPtr.Branches.Prefix && configPtr.Tags.Suffix == configPtr.Branches.Suffix {
+		e.add("tags.prefix", "tags prefix and suffix are the same as branches ones, tag and branch paths would clash")
+	}
+	mergeRequestsPrefix := configPtr.MergeRequests.Prefix
+	if mergeRequestsPrefix == "" {
+		mergeRequestsPrefix = defaultMergeRequestsPrefix
+	}
+	if configPtr.MergeRequests.Enabled && mergeRequestsPrefix == configPtr.Branches.Prefix {
+		e.add("merge-requests.prefix", "is the same as branches.prefix, merge request and branch paths would clash")
+	}
+}
+
+// checkPathTemplate renders the template for sample projects and branches,
+// they must get distinct paths and worktrees of a repo must be in one directory
+func (e *configErrors) checkPathTemplate(configPtr *ConfigStruct) {
+	tmpl, err := parsePathTemplate(configPtr.PathTemplate)
+	if err != nil {
+		e.add("path-template", "%s", err)
+		return
+	}
+
+	project := &PathFields{Path: "group/project", Namespace: "group", Name: "project"}
+	other := &PathFields{Path: "group/other", Namespace: "group", Name: "other"}
+	if configPtr.ExpandBranches {
+		branches := &configPtr.Branches
+		project = project.withBranch("main", branches.Prefix, "main", branches.Suffix)
+		other = other.withBranch("main", branches.Prefix, "main", branches.Suffix)
+	}
+	projectPath, err := renderPath(tmpl, project)
+	if err != nil {
+		e.add("path-template", "%s", err)
+		return
+	}
+	otherPath, err := renderPath(tmpl, other)
+	if err != nil {
+		e.add("path-template", "%s", err)
+		return
+	}
+	if !configPtr.ExpandBranches {
+		if projectPath == otherPath {
+			e.add("path-template", "renders the same path %s for different projects", projectPath)
+		}
+		return
+	}
+
+	repoPath := filepath.Dir(projectPath)
+	if repoPath == "." || repoPath == filepath.Dir(otherPath) {
+		e.add("path-template", "must render worktrees into directories of projects, got %s and %s", projectPath, otherPath)
+		return
+	}
+	branchPath, err := renderPath(tmpl, project.withBranch("develop", configPtr.Branches.Prefix, "develop", configPtr.Branches.Suffix))
+	if err != nil {
+		e.add("path-template", "%s", err)
+		return
+	}
+	if branchPath == projectPath || filepath.Dir(branchPath) != repoPath {
+		e.add("path-template", "must render branches of a project into distinct worktrees of one directory, got %s and %s", projectPath, branchPath)
+	}
+}
+
+func (e *configErrors) checkSlugPart(key string, value string) {
+	if strings.ContainsAny(value, "/\x00"+unsafeSlugChars) {
+		e.add(key, "must not contain slashes, NUL or characters unsafe in file names (%s), got %q", unsafeSlugChars, value)
+	}
+}
@@ -0,0 +1,142 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package clone
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/Logunov/heydevops/helpers"
+)
+
+func TestValidateNamespaceKind(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider string
+		repos    ReposStruct
+		sources  []*SourceStruct
+		keys     []string
+	}{
+		{"gitlab", "", ReposStruct{NamespaceKind: "group"}, nil, nil},
+		{"gitea", "gitea", ReposStruct{NamespaceKind: "group"}, nil, []string{"repos.namespace-kind"}},
+		{"gitea without rule", "gitea", ReposStruct{}, nil, nil},
+		{"gitea source inherits rule", "", ReposStruct{NamespaceKind: "user"},
+			[]*SourceStruct{{Name: "a", Subdir: "a", Provider: "gitea", GitLabURL: "https://gitea.example.com/", Token: "t"}},
+			[]string{"repos.namespace-kind"}},
+		{"gitea source own rule", "", ReposStruct{},
+			[]*SourceStruct{{Name: "a", Subdir: "a", Provider: "gitea", GitLabURL: "https://gitea.example.com/", Token: "t", Repos: &ReposStruct{NamespaceKind: "user"}}},
+			[]string{"sources[0].repos.namespace-kind"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			configPtr := &ConfigStruct{
+				Provider:           test.provider,
+				GitLabURL:          "https://git.example.com/",
+				CloneThreadsCount:  1,
+				ListOptionsPerPage: 20,
+				Retry:              RetryConfig{Attempts: 1},
+				Repos:              test.repos,
+				Sources:            test.sources,
+			}
+
+			var keys []string
+			for _, err := range Validate(configPtr) {
+				keys = append(keys, err.Key)
+			}
+			if strings.Join(keys, " ") != strings.Join(test.keys, " ") {
+				t.Errorf("errors of keys %v, want %v", keys, test.keys)
+			}
+		})
+	}
+}
+
+func TestValidateOffline(t *testing.T) {
+	tests := []struct {
+		name    string
+		offline bool
+		sources []*SourceStruct
+		keys    []string
+	}{
+		{"sync without url", false, nil, []string{"gitlab-url"}},
+		{"offline without url", true, nil, nil},
+		{"sync of source without url", false, []*SourceStruct{{Name: "a", Subdir: "a", Provider: "gitee"}},
+			[]string{"sources[0].provider", "sources[0].gitlab-url", "sources[0].token"}},
+		// Subdirs are walked by offline commands, so they are still checked
+		{"offline source", true, []*SourceStruct{{Name: "a", Subdir: "../a", Provider: "gitee"}}, []string{"sources[0].subdir"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			configPtr := &ConfigStruct{
+				CloneThreadsCount:  1,
+				ListOptionsPerPage: 20,
+				Retry:              RetryConfig{Attempts: 1},
+				Sources:            test.sources,
+				Offline:            test.offline,
+			}
+
+			var keys []string
+			for _, err := range Validate(configPtr) {
+				keys = append(keys, err.Key)
+			}
+			if strings.Join(keys, " ") != strings.Join(test.keys, " ") {
+				t.Errorf("errors of keys %v, want %v", keys, test.keys)
+			}
+		})
+	}
+}
+
+func TestValidateSourceToken(t *testing.T) {
+	tests := []struct {
+		name      string
+		gitLabURL string
+		source    *SourceStruct
+		message   string
+	}{
+		{"same host", "https://git.example.com/", &SourceStruct{GitLabURL: "https://git.example.com/"}, ""},
+		{"inherited url", "https://git.example.com/", &SourceStruct{}, ""},
+		{"another host", "https://git.example.com/", &SourceStruct{GitLabURL: "https://gitlab.com/"},
+			"is required, top level token is for another hosting"},
+		{"another provider", "https://git.example.com/", &SourceStruct{Provider: "github"},
+			"is required, top level token is for another hosting"},
+		{"no top level url", "", &SourceStruct{GitLabURL: "https://gitlab.com/"},
+			"is required, top level token isn't passed to sources of other hosts without top level gitlab-url"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.source.Name, test.source.Subdir = "a", "a"
+			configPtr := &ConfigStruct{
+				GitLabURL:          test.gitLabURL,
+				Token:              "base-token",
+				CloneThreadsCount:  1,
+				ListOptionsPerPage: 20,
+				Retry:              RetryConfig{Attempts: 1},
+				Sources:            []*SourceStruct{test.source},
+			}
+
+			var message string
+			for _, err := range Validate(configPtr) {
+				if err.Key == "sources[0].token" {
+					message = err.Message
+				}
+			}
+			if message != test.message {
+				t.Errorf("token error %q, want %q", message, test.message)
+			}
+		})
+	}
+}
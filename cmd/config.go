@@ -0,0 +1,379 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Logunov/heydevops/clone"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"go.yaml.in/yaml/v3"
+)
+
+// Types of config values, they are the same as pflag ones
+const (
+	typeBool     = "bool"
+	typeInt      = "int"
+	typeString   = "string"
+	typeStrings  = "stringSlice"
+	typeDuration = "duration"
+	typeMappings = "mappings"
+)
+
+// typeNames describe types in errors
+var typeNames = map[string]string{
+	typeBool:     "true or false",
+	typeInt:      "an integer",
+	typeString:   "a string",
+	typeDuration: "a duration like 30s",
+}
+
+var (
+	// configSections are config file keys which have no flags
+	configSections = map[string]map[string]string{
+		"filters": {
+			"owned":      typeBool,
+			"membership": typeBool,
+			"starred":    typeBool,
+			"archived":   typeBool,
+			"visibility": typeString,
+		},
+		"repos": {
+			"clone":              typeStrings,
+			"skip":               typeStrings,
+			"topics":             typeStrings,
+			"archived":           typeBool,
+			"visibility":         typeStrings,
+			"active-within-days": typeInt,
+			"empty-repo":         typeBool,
+			"forks":              typeBool,
+			"namespace-kind":     typeString,
+		},
+		"branches": {
+			"prefix":             typeString,
+			"suffix":             typeString,
+			"slash":              typeString,
+			"clone":              typeStrings,
+			"skip":               typeStrings,
+			"protected-only":     typeBool,
+			"max-age-days":       typeInt,
+			"open-merge-request": typeBool,
+			"latest":             typeInt,
+		},
+		"tags": {
+			"prefix":        typeString,
+			"suffix":        typeString,
+			"slash":         typeString,
+			"clone":         typeStrings,
+			"skip":          typeStrings,
+			"latest-semver": typeInt,
+		},
+		"merge-requests": {
+			"enabled":         typeBool,
+			"prefix":          typeString,
+			"labels":          typeStrings,
+			"authors":         typeStrings,
+			"target-branches": typeStrings,
+			"draft":           typeBool,
+		},
+	}
+
+	// configLists are config file keys of lists of mappings, values are keys of every item
+	configLists = map[string]map[string]string{
+		"sources": sourceKeys,
+		"clone-options": {
+			"repos":  typeStrings,
+			"filter": typeString,
+			"depth":  typeInt,
+			"sparse": typeStrings,
+		},
+	}
+
+	configCmd = &cobra.Command{
+		Use:   "config",
+		Short: "Config file commands",
+	}
+
+	configValidateCmd = &cobra.Command{
+		Use:   "validate",
+		Short: "Checks config file and flags without syncing anything",
+		Long: `Checks config file strictly: unknown keys and values of wrong types are rejected,
+every regexp is compiled, URLs, thread counts and branch slug settings are checked.
+Every error points to the key and its line in config file. The same checks run on start of every command.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			initConfig()
+			initLogger()
+
+			if !checkConfig(cmd, os.Stdout, os.Stderr) {
+				os.Exit(1)
+			}
+		},
+	}
+)
+
+func init() {
+	configCmd.AddCommand(configValidateCmd)
+	rootCmd.AddCommand(configCmd)
+}
+
+// checkConfig prints errors of config file, flags and environment variables to stderr and
+// returns true when there are none. Missing config file isn't an error, the rest is checked without it
+func checkConfig(cmd *cobra.Command, stdout io.Writer, stderr io.Writer) bool {
+	configFile := viper.ConfigFileUsed()
+	_, err := os.Stat(configFile)
+	fileMissing := errors.Is(err, os.ErrNotExist)
+	if fileMissing {
+		fmt.Fprintln(stdout, "No config file "+configFile+", only flags and environment variables are checked")
+	}
+
+	errs := validateConfig(cmd, newCoreConfig())
+	for _, err := range errs {
+		fmt.Fprintln(stderr, err)
+	}
+	if len(errs) > 0 {
+		return false
+	}
+	if fileMissing {
+		fmt.Fprintln(stdout, "Flags and environment variables are valid")
+	} else {
+		fmt.Fprintln(stdout, configFile+" is valid")
+	}
+	return true
+}
+
+// validateConfig checks config file schema and values of the core config,
+// errors of keys set in config file point to their lines
+func validateConfig(cmd *cobra.Command, coreConfig *clone.ConfigStruct) []*clone.ConfigError {
+	configFile := viper.ConfigFileUsed()
+	errs, lines := checkConfigFile(configFile, configKeys(cmd.Root().PersistentFlags()))
+	if lines == nil {
+		// Values weren't read from unparsable config file
+		return errs
+	}
+
+	invalid := make(map[string]bool)
+	for _, err := range errs {
+		invalid[err.Key] = true
+	}
+	for _, err := range clone.Validate(coreConfig) {
+		if invalid[err.Key] {
+			// Value of wrong type is reported already
+			continue
+		}
+		if line := keyLine(lines, err.Key); line > 0 {
+			err.File, err.Line = configFile, line
+		}
+		errs = append(errs, err)
+	}
+
+	// Errors of config file go first in order of lines
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].Line > 0 && (errs[j].Line == 0 || errs[i].Line < errs[j].Line)
+	})
+	return errs
+}
+
+// keyLine returns line of the key, missing keys of list items point to the item
+func keyLine(lines map[string]int, key string) int {
+	for {
+		if line, ok := lines[key]; ok {
+			return line
+		}
+		parent := strings.LastIndexAny(key, ".[")
+		if parent <= 0 || !strings.Contains(key[:parent], "[") {
+			return 0
+		}
+		key = key[:parent]
+	}
+}
+
+// checkConfigFile rejects unknown keys and values of wrong types, it returns lines of all keys
+// and list items found, they are nil when config file can't be parsed.
+// Missing config file is fine, flags and environment are enough.
+func checkConfigFile(configFile string, keys map[string]string) ([]*clone.ConfigError, map[string]int) {
+	lines := make(map[string]int)
+
+	content, err := os.ReadFile(configFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, lines
+	}
+	if err != nil {
+		return []*clone.ConfigError{{Key: configFile, Message: err.Error()}}, nil
+	}
+
+	// Decoding into values catches duplicate keys, decoding into nodes doesn't
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(content, &values); err != nil {
+		return []*clone.ConfigError{{Key: configFile, Message: err.Error()}}, nil
+	}
+	var document yaml.Node
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return []*clone.ConfigError{{Key: configFile, Message: err.Error()}}, nil
+	}
+	if len(document.Content) == 0 {
+		return nil, lines
+	}
+
+	checker := &configChecker{file: configFile, lines: lines}
+	checker.checkMapping("", document.Content[0], keys)
+	return checker.errs, lines
+}
+
+// configKeys returns types of top level config keys, they are flags and sections which have no type
+func configKeys(flags *pflag.FlagSet) map[string]string {
+	keys := make(map[string]string)
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if flag.Name != flagConfig {
+			keys[flag.Name] = flag.Value.Type()
+		}
+	})
+	for section := range configSections {
+		keys[section] = ""
+	}
+	for list := range configLists {
+		keys[list] = typeMappings
+	}
+	return keys
+}
+
+type configChecker struct {
+	file  string
+	lines map[string]int
+	errs  []*clone.ConfigError
+}
+
+func (c *configChecker) add(key string, line int, format string, args ...interface{}) {
+	c.errs = append(c.errs, &clone.ConfigError{Key: key, Message: fmt.Sprintf(format, args...), File: c.file, Line: line})
+}
+
+func (c *configChecker) checkMapping(prefix string, node *yaml.Node, keys map[string]string) {
+	if node.Kind != yaml.MappingNode {
+		c.add(strings.TrimSuffix(prefix, "."), node.Line, "must be a mapping of keys")
+		return
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keyNode, valueNode := node.Content[i], node.Content[i+1]
+		key := prefix + keyNode.Value
+
+		c.lines[key] = keyNode.Line
+
+		valueType, known := keys[keyNode.Value]
+		switch {
+		case !known:
+			c.add(key, keyNode.Line, "unknown key%s", suggestKey(keyNode.Value, keys))
+		case valueNode.Tag == "!!null":
+			// Empty value is the same as absent key
+		case valueType == "":
+			c.checkMapping(key+".", valueNode, configSections[keyNode.Value])
+		default:
+			c.checkValue(key, valueNode, valueType)
+		}
+	}
+}
+
+func (c *configChecker) checkValue(key string, node *yaml.Node, valueType string) {
+	if valueType == typeMappings {
+		if node.Kind != yaml.SequenceNode {
+			c.add(key, node.Line, "must be a list")
+			return
+		}
+		for i, item := range node.Content {
+			itemKey := fmt.Sprintf("%s[%d]", key, i)
+			c.lines[itemKey] = item.Line
+			c.checkMapping(itemKey+".", item, configLists[key])
+		}
+		return
+	}
+	if valueType == typeStrings {
+		if node.Kind != yaml.SequenceNode {
+			c.add(key, node.Line, "must be a list")
+			return
+		}
+		for i, item := range node.Content {
+			itemKey := fmt.Sprintf("%s[%d]", key, i)
+			c.lines[itemKey] = item.Line
+			if item.Kind != yaml.ScalarNode {
+				c.add(itemKey, item.Line, "must be a string")
+			}
+		}
+		return
+	}
+
+	if node.Kind != yaml.ScalarNode {
+		c.add(key, node.Line, "must be %s", typeNames[valueType])
+		return
+	}
+	var err error
+	switch valueType {
+	case typeBool:
+		_, err = strconv.ParseBool(node.Value)
+	case typeInt:
+		_, err = strconv.Atoi(node.Value)
+	case typeDuration:
+		_, err = time.ParseDuration(node.Value)
+	}
+	if err != nil {
+		c.add(key, node.Line, "must be %s, got %q", typeNames[valueType], node.Value)
+	}
+}
+
+// suggestKey returns a hint with the known key differing from the typo by a couple of characters
+func suggestKey(key string, keys map[string]string) string {
+	var candidates []string
+	for knownKey := range keys {
+		if editDistance(key, knownKey) <= 2 {
+			candidates = append(candidates, knownKey)
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	sort.Strings(candidates)
+	return ", did you mean " + strings.Join(candidates, " or ") + "?"
+}
+
+// editDistance is Levenshtein distance of a and b
+func editDistance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
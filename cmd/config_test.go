@@ -0,0 +1,149 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// testKeys are top level keys like configKeys returns for flags of the root command
+var testKeys = map[string]string{
+	"gitlab-url":    typeString,
+	"gitlab-token":  typeString,
+	"concurrency":   typeInt,
+	"bare":          typeBool,
+	"timeout":       typeDuration,
+	"branches":      "",
+	"clone-options": typeMappings,
+}
+
+func TestCheckConfigFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		errs    []string
+	}{
+		{"empty", "", nil},
+		{"valid", "gitlab-url: https://gitlab.com/\nconcurrency: 4\nbare: true\ntimeout: 30s\nbranches:\n  clone: [main]\n  latest: 3\nclone-options:\n  - repos: [a]\n    depth: 1\n", nil},
+		{"null value", "gitlab-token:\nbranches:\n", nil},
+		{"unknown key", "gitlab-urll: x\n", []string{"1: gitlab-urll: unknown key, did you mean gitlab-url?"}},
+		{"unknown nested key", "branches:\n  clone: [main]\n  latst: 1\n", []string{"3: branches.latst: unknown key, did you mean latest?"}},
+		{"wrong types", "concurrency: many\nbare: yes please\ntimeout: 30\n", []string{
+			`1: concurrency: must be an integer, got "many"`,
+			`2: bare: must be true or false, got "yes please"`,
+			`3: timeout: must be a duration like 30s, got "30"`,
+		}},
+		{"not a mapping", "branches: [main]\n", []string{"1: branches: must be a mapping of keys"}},
+		{"not a list", "branches:\n  clone: main\nclone-options:\n  repos: [a]\n", []string{"2: branches.clone: must be a list", "4: clone-options: must be a list"}},
+		{"list item", "clone-options:\n  - repos: [a]\n  - depth: deep\n    sparse: [x, [y]]\n", []string{
+			`3: clone-options[1].depth: must be an integer, got "deep"`,
+			"4: clone-options[1].sparse[1]: must be a string",
+		}},
+		{"duplicate key", "bare: true\nbare: false\n", []string{"mapping key \"bare\" already defined"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			configFile := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(configFile, []byte(test.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			errs, lines := checkConfigFile(configFile, testKeys)
+			if len(errs) != len(test.errs) {
+				t.Fatalf("errors are %v, want %v", errs, test.errs)
+			}
+			for i, err := range errs {
+				got := strings.TrimPrefix(err.Error(), configFile+":")
+				if !strings.Contains(got, test.errs[i]) {
+					t.Errorf("error is %q, want %q", got, test.errs[i])
+				}
+			}
+			if len(test.errs) == 0 && len(lines) == 0 && test.content != "" {
+				t.Errorf("lines of keys are empty")
+			}
+		})
+	}
+}
+
+func TestCheckConfigFileMissing(t *testing.T) {
+	errs, lines := checkConfigFile(filepath.Join(t.TempDir(), "missing.yaml"), testKeys)
+	if errs != nil || lines == nil {
+		t.Errorf("missing config file gives %v, %v, want no errors", errs, lines)
+	}
+}
+
+func TestSuggestKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"gitlab-urll", ", did you mean gitlab-url?"},
+		{"gitlab-tokn", ", did you mean gitlab-token?"},
+		{"gitlab-ur", ", did you mean gitlab-url?"},
+		{"bar", ", did you mean bare?"},
+		{"gitlab-tokens", ", did you mean gitlab-token?"},
+		{"concurency", ", did you mean concurrency?"},
+		{"something", ""},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := suggestKey(test.key, testKeys); got != test.want {
+			t.Errorf("suggestKey(%q) = %q, want %q", test.key, got, test.want)
+		}
+	}
+}
+
+func TestCheckConfigWithoutFile(t *testing.T) {
+	savedFile, savedURL := viper.ConfigFileUsed(), viper.Get("gitlab-url")
+	t.Cleanup(func() {
+		viper.SetConfigFile(savedFile)
+		viper.Set("gitlab-url", savedURL)
+	})
+
+	tests := []struct {
+		name      string
+		gitlabURL string
+		valid     bool
+		output    string
+	}{
+		{"valid flags", "https://gitlab.example.com/", true, "Flags and environment variables are valid"},
+		{"invalid flags", "gitlab.example.com", false, "gitlab-url"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			viper.SetConfigFile(filepath.Join(t.TempDir(), "missing.yaml"))
+			viper.Set("gitlab-url", test.gitlabURL)
+
+			var stdout, stderr strings.Builder
+			if valid := checkConfig(rootCmd, &stdout, &stderr); valid != test.valid {
+				t.Errorf("checkConfig = %v, want %v, stderr %q", valid, test.valid, stderr.String())
+			}
+			if !strings.Contains(stdout.String(), "No config file") {
+				t.Errorf("stdout %q doesn't tell config file is missing", stdout.String())
+			}
+			if !strings.Contains(stdout.String()+stderr.String(), test.output) {
+				t.Errorf("output %q %q, want %q", stdout.String(), stderr.String(), test.output)
+			}
+		})
+	}
+}
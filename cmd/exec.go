@@ -0,0 +1,71 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/Logunov/heydevops/clone"
+	"github.com/Logunov/heydevops/helpers"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	flagFailFast = "fail-fast"
+
+	// execCmd represents the exec command
+	execCmd = &cobra.Command{
+		Use:   "exec -- <command> [args...]",
+		Short: "Runs command in every cloned repo and branch worktree",
+		Long: `Runs command in every local path matched by repos and branches regexps in parallel,
+prints output of every path and exit codes summary. HEYDEVOPS_REPO, HEYDEVOPS_BRANCH
+and HEYDEVOPS_PATH environment variables are set for the command.
+
+heydevops exec -b -- terraform fmt -check`,
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			initCore(cmd, true)
+
+			failFast, err := cmd.Flags().GetBool(flagFailFast)
+			helpers.CheckDebug(err)
+
+			results, err := clone.Exec(args, failFast)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			if output := viper.GetString(flagOutput); output != "" {
+				helpers.CheckError(clone.WriteOutput(os.Stdout, output, results))
+			} else {
+				clone.PrintExec(os.Stdout, results)
+			}
+
+			for _, result := range results {
+				if result.Status == clone.StatusFailed {
+					os.Exit(1)
+				}
+			}
+		},
+	}
+)
+
+func init() {
+	execCmd.Flags().Bool(flagFailFast, false, "If true, paths not started yet are skipped after the first failure")
+	rootCmd.AddCommand(execCmd)
+}
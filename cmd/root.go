@@ -18,14 +18,20 @@ along with this program. If not, see <http://www.gnu.org/licenses/>.
 package cmd
 
 import (
+	"bufio"
+	"errors"
+	"fmt"
 	"github.com/Logunov/heydevops/clone"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/Logunov/heydevops/helpers"
+	"github.com/Logunov/heydevops/provider"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"golang.org/x/term"
 )
 
 var (
@@ -36,10 +42,29 @@ var (
 	flagGitlabURL          = "gitlab-url"
 	flagGitlabAPIURL       = "gitlab-api-url"
 	flagDryRun             = "dry-run"
+	flagIncremental        = "incremental"
+	flagPrune              = "prune"
+	flagYes                = "yes"
+	flagGitBackend         = "git-backend"
+	flagLayout             = "layout"
+	flagMirrorWikis        = "mirror-wikis"
+	flagCloneProtocol      = "clone-protocol"
+	flagUpdateStrategy     = "update-strategy"
+	flagOnDirty            = "on-dirty"
+	flagOutput             = "output"
+	flagStateFile          = "state-file"
 	flagExpandBranches     = "expand-branches"
+	flagProvider           = "provider"
 	flagLogLevel           = "log-level"
 	flagCloneThreadsCount  = "clone-threads"
 	flagListOptionsPerPage = "list-options-per-page"
+	flagGroups             = "groups"
+	flagRetryAttempts      = "retry-attempts"
+	flagRetryDelay         = "retry-delay"
+	flagRetryMaxDelay      = "retry-max-delay"
+	flagSource             = "source"
+	flagPathTemplate       = "path-template"
+	flagSlugEncoding       = "slug-encoding"
 
 	// rootCmd represents the base command when called without any subcommands
 	rootCmd = &cobra.Command{
@@ -47,40 +72,19 @@ var (
 		Short: "Hey, DevOps!",
 		Long:  "heydevops clones group from GitLab to local directory",
 		Run: func(cmd *cobra.Command, args []string) {
-			initConfig()
-			initLogger()
-
-			//viper.Unmarshal(&f.d, func(config *mapstructure.DecoderConfig) {
-			//	config.ErrorUnused = true
-			//})
-
-			var coreConfig = clone.ConfigStruct{
-				Logger:             log,
-				DryRun:             viper.GetBool(flagDryRun),
-				ExpandBranches:     viper.GetBool(flagExpandBranches),
-				GitLabURL:          viper.GetString(flagGitlabURL),
-				GitLabAPIURL:       viper.GetString(flagGitlabAPIURL),
-				Token:              viper.GetString(flagToken),
-				CloneThreadsCount:  viper.GetInt(flagCloneThreadsCount),
-				ListOptionsPerPage: viper.GetInt(flagListOptionsPerPage),
-				Repos: clone.SkipCloneStringsStruct{
-					Clone: viper.GetStringSlice("repos.clone"),
-					Skip:  viper.GetStringSlice("repos.skip"),
-				},
-				Branches: clone.BranchesStruct{
-					Prefix: viper.GetString("branches.prefix"),
-					Suffix: viper.GetString("branches.suffix"),
-					Slash:  viper.GetString("branches.slash"),
-					SkipCloneStringsStruct: clone.SkipCloneStringsStruct{
-						Clone: viper.GetStringSlice("branches.clone"),
-						Skip:  viper.GetStringSlice("branches.skip"),
-					},
-				},
-			}
-			log.Trace("Core config: ", coreConfig)
+			initCore(cmd, false)
 
-			clone.Init(&coreConfig)
-			clone.Clone()
+			output := viper.GetString(flagOutput)
+			report := clone.Clone()
+			if output != "" {
+				report.Print(os.Stderr)
+				helpers.CheckError(report.Plan.Write(os.Stdout, output))
+			} else {
+				report.Print(os.Stdout)
+			}
+			if report.Failed() {
+				os.Exit(1)
+			}
 		},
 	}
 )
@@ -99,13 +103,32 @@ func init() {
 
 	rootCmd.PersistentFlags().StringP(flagConfig, "c", "./heydevops.yaml", "config file")
 	rootCmd.PersistentFlags().BoolP(flagDryRun, "n", false, "If true, don't do any changes")
+	rootCmd.PersistentFlags().BoolP(flagIncremental, "i", false, "If true, only projects and branches changed since the last run are synced")
+	rootCmd.PersistentFlags().Bool(flagPrune, false, "If true, local repos and worktrees of projects and branches deleted upstream are removed")
+	rootCmd.PersistentFlags().BoolP(flagYes, "y", false, "If true, removal of projects deleted upstream by --prune isn't asked for")
+	rootCmd.PersistentFlags().String(flagGitBackend, "binary", "Git implementation: binary (installed git) or go-git")
+	rootCmd.PersistentFlags().String(flagLayout, "submodules", "Local layout: submodules of the current git repo, plain clones \nor bare mirrors")
+	rootCmd.PersistentFlags().Bool(flagMirrorWikis, false, "If true, wikis are mirrored too in mirror layout")
+	rootCmd.PersistentFlags().String(flagCloneProtocol, "ssh", "Clone protocol: ssh or https (authenticated with the token)")
+	rootCmd.PersistentFlags().String(flagUpdateStrategy, "ff-only", "How existing branches are updated: ff-only, rebase, fetch-only or reset-hard")
+	rootCmd.PersistentFlags().String(flagOnDirty, "skip", "What to do with paths having uncommitted changes: skip or stash")
+	rootCmd.PersistentFlags().StringP(flagOutput, "o", "", "Print plan of discovered projects, branches and git actions: json or yaml")
+	rootCmd.PersistentFlags().String(flagStateFile, ".heydevops/state.json", "State file remembering synced projects and branches")
 	rootCmd.PersistentFlags().BoolP(flagExpandBranches, "b", false, "If true, branches will be expanded into git worktrees")
+	rootCmd.PersistentFlags().String(flagProvider, "gitlab", "Hosting provider: gitlab, github or gitea")
 	rootCmd.PersistentFlags().StringP(flagGitlabAPIURL, "a", "", "GitLab API address if it is located at non-default path")
 	rootCmd.PersistentFlags().StringP(flagGitlabURL, "u", "", "GitLab address")
 	rootCmd.PersistentFlags().Int(flagCloneThreadsCount, 10, "Working threads count")
-	rootCmd.PersistentFlags().Int(flagListOptionsPerPage, 10, "For paginated GitLab API call result sets, the number of results \nto include per page")
+	rootCmd.PersistentFlags().StringSliceP(flagGroups, "g", nil, "Groups (with subgroups) to clone projects from, \nall visible projects are listed if empty")
+	rootCmd.PersistentFlags().Int(flagRetryAttempts, 3, "Attempts of API calls and git network operations failed with transient errors")
+	rootCmd.PersistentFlags().Duration(flagRetryDelay, time.Second, "Delay before the first retry, it doubles with every next one")
+	rootCmd.PersistentFlags().Duration(flagRetryMaxDelay, 30*time.Second, "Maximal delay between retries unless the server asks to wait longer")
+	rootCmd.PersistentFlags().String(flagPathTemplate, "", "Go template of local paths of repos and branch worktrees, \n"+clone.DefaultPathTemplate+" if empty")
+	rootCmd.PersistentFlags().String(flagSlugEncoding, "lenient", "How branch and tag names are encoded into directory names: lenient or strict (reversible)")
+	rootCmd.PersistentFlags().StringSlice(flagSource, nil, "Names of sources from config file to sync, all of them if empty")
+	rootCmd.PersistentFlags().Int(flagListOptionsPerPage, 100, "For paginated GitLab API call result sets, the number of results \nto include per page")
 	rootCmd.PersistentFlags().StringP(flagLogLevel, "l", "warn", "Level of logging: \nPANIC, FATAL, ERROR, WARN, INFO, DEBUG, TRACE")
-	rootCmd.PersistentFlags().StringP(flagToken, "t", "", "GitLab token from http://<gitlab>/profile/personal_access_tokens page")
+	rootCmd.PersistentFlags().StringP(flagToken, "t", "", "GitLab token from http://<gitlab>/profile/personal_access_tokens page \nor reference to it: cmd:<command>, file:<path>, env:<variable>, credential:[url]")
 
 	var err error
 
@@ -115,9 +138,45 @@ func init() {
 	err = viper.BindPFlag(flagDryRun, rootCmd.PersistentFlags().Lookup(flagDryRun))
 	helpers.CheckDebug(err)
 
+	err = viper.BindPFlag(flagIncremental, rootCmd.PersistentFlags().Lookup(flagIncremental))
+	helpers.CheckDebug(err)
+
+	err = viper.BindPFlag(flagPrune, rootCmd.PersistentFlags().Lookup(flagPrune))
+	helpers.CheckDebug(err)
+
+	err = viper.BindPFlag(flagYes, rootCmd.PersistentFlags().Lookup(flagYes))
+	helpers.CheckDebug(err)
+
+	err = viper.BindPFlag(flagGitBackend, rootCmd.PersistentFlags().Lookup(flagGitBackend))
+	helpers.CheckDebug(err)
+
+	err = viper.BindPFlag(flagLayout, rootCmd.PersistentFlags().Lookup(flagLayout))
+	helpers.CheckDebug(err)
+
+	err = viper.BindPFlag(flagMirrorWikis, rootCmd.PersistentFlags().Lookup(flagMirrorWikis))
+	helpers.CheckDebug(err)
+
+	err = viper.BindPFlag(flagCloneProtocol, rootCmd.PersistentFlags().Lookup(flagCloneProtocol))
+	helpers.CheckDebug(err)
+
+	err = viper.BindPFlag(flagUpdateStrategy, rootCmd.PersistentFlags().Lookup(flagUpdateStrategy))
+	helpers.CheckDebug(err)
+
+	err = viper.BindPFlag(flagOnDirty, rootCmd.PersistentFlags().Lookup(flagOnDirty))
+	helpers.CheckDebug(err)
+
+	err = viper.BindPFlag(flagOutput, rootCmd.PersistentFlags().Lookup(flagOutput))
+	helpers.CheckDebug(err)
+
+	err = viper.BindPFlag(flagStateFile, rootCmd.PersistentFlags().Lookup(flagStateFile))
+	helpers.CheckDebug(err)
+
 	err = viper.BindPFlag(flagExpandBranches, rootCmd.PersistentFlags().Lookup(flagExpandBranches))
 	helpers.CheckDebug(err)
 
+	err = viper.BindPFlag(flagProvider, rootCmd.PersistentFlags().Lookup(flagProvider))
+	helpers.CheckDebug(err)
+
 	err = viper.BindPFlag(flagGitlabAPIURL, rootCmd.PersistentFlags().Lookup(flagGitlabAPIURL))
 	helpers.CheckDebug(err)
 
@@ -127,9 +186,30 @@ func init() {
 	err = viper.BindPFlag(flagCloneThreadsCount, rootCmd.PersistentFlags().Lookup(flagCloneThreadsCount))
 	helpers.CheckDebug(err)
 
+	err = viper.BindPFlag(flagRetryAttempts, rootCmd.PersistentFlags().Lookup(flagRetryAttempts))
+	helpers.CheckDebug(err)
+
+	err = viper.BindPFlag(flagRetryDelay, rootCmd.PersistentFlags().Lookup(flagRetryDelay))
+	helpers.CheckDebug(err)
+
+	err = viper.BindPFlag(flagRetryMaxDelay, rootCmd.PersistentFlags().Lookup(flagRetryMaxDelay))
+	helpers.CheckDebug(err)
+
+	err = viper.BindPFlag(flagSource, rootCmd.PersistentFlags().Lookup(flagSource))
+	helpers.CheckDebug(err)
+
+	err = viper.BindPFlag(flagPathTemplate, rootCmd.PersistentFlags().Lookup(flagPathTemplate))
+	helpers.CheckDebug(err)
+
+	err = viper.BindPFlag(flagSlugEncoding, rootCmd.PersistentFlags().Lookup(flagSlugEncoding))
+	helpers.CheckDebug(err)
+
 	err = viper.BindPFlag(flagListOptionsPerPage, rootCmd.PersistentFlags().Lookup(flagListOptionsPerPage))
 	helpers.CheckDebug(err)
 
+	err = viper.BindPFlag(flagGroups, rootCmd.PersistentFlags().Lookup(flagGroups))
+	helpers.CheckDebug(err)
+
 	err = viper.BindPFlag(flagLogLevel, rootCmd.PersistentFlags().Lookup(flagLogLevel))
 	helpers.CheckDebug(err)
 
@@ -138,6 +218,106 @@ func init() {
 
 }
 
+// initCore reads and validates config, sets logger up and initializes clone core with it,
+// offline commands work on local repos only and don't need hosting settings
+func initCore(cmd *cobra.Command, offline bool) *clone.ConfigStruct {
+	initConfig()
+	initLogger()
+
+	if viper.GetString(flagOutput) != "" {
+		// Keep stdout clean for machine-readable output
+		log.SetOutput(os.Stderr)
+	}
+
+	coreConfig := newCoreConfig()
+	coreConfig.Offline = offline
+	if errs := validateConfig(cmd, coreConfig); len(errs) > 0 {
+		for _, err := range errs {
+			log.Error(err)
+		}
+		log.Fatal("Config is invalid")
+	}
+
+	clone.Init(coreConfig)
+	return coreConfig
+}
+
+// newCoreConfig makes clone core config of flags, config file and environment
+func newCoreConfig() *clone.ConfigStruct {
+	var coreConfig = clone.ConfigStruct{
+		Logger:             log,
+		DryRun:             viper.GetBool(flagDryRun),
+		Incremental:        viper.GetBool(flagIncremental),
+		Prune:              viper.GetBool(flagPrune),
+		GitBackend:         viper.GetString(flagGitBackend),
+		Layout:             viper.GetString(flagLayout),
+		MirrorWikis:        viper.GetBool(flagMirrorWikis),
+		CloneProtocol:      viper.GetString(flagCloneProtocol),
+		UpdateStrategy:     viper.GetString(flagUpdateStrategy),
+		OnDirty:            viper.GetString(flagOnDirty),
+		StateFile:          viper.GetString(flagStateFile),
+		ExpandBranches:     viper.GetBool(flagExpandBranches),
+		Provider:           viper.GetString(flagProvider),
+		GitLabURL:          viper.GetString(flagGitlabURL),
+		GitLabAPIURL:       viper.GetString(flagGitlabAPIURL),
+		Token:              helpers.Secret(viper.GetString(flagToken)),
+		CloneThreadsCount:  viper.GetInt(flagCloneThreadsCount),
+		ListOptionsPerPage: viper.GetInt(flagListOptionsPerPage),
+		Groups:             viper.GetStringSlice(flagGroups),
+		PathTemplate:       viper.GetString(flagPathTemplate),
+		SlugEncoding:       viper.GetString(flagSlugEncoding),
+		Filters:            newFilters(viper.GetViper()),
+		Retry: helpers.RetryConfig{
+			Attempts: viper.GetInt(flagRetryAttempts),
+			Delay:    viper.GetDuration(flagRetryDelay),
+			MaxDelay: viper.GetDuration(flagRetryMaxDelay),
+		},
+		Repos:    newRepos(viper.GetViper()),
+		Branches: newBranches(viper.GetViper()),
+		Tags: clone.TagsStruct{
+			Prefix: viper.GetString("tags.prefix"),
+			Suffix: viper.GetString("tags.suffix"),
+			Slash:  viper.GetString("tags.slash"),
+			SkipCloneStringsStruct: clone.SkipCloneStringsStruct{
+				Clone: viper.GetStringSlice("tags.clone"),
+				Skip:  viper.GetStringSlice("tags.skip"),
+			},
+			LatestSemver: viper.GetInt("tags.latest-semver"),
+		},
+		MergeRequests: clone.MergeRequestsStruct{
+			Enabled:        viper.GetBool("merge-requests.enabled"),
+			Prefix:         viper.GetString("merge-requests.prefix"),
+			Labels:         viper.GetStringSlice("merge-requests.labels"),
+			Authors:        viper.GetStringSlice("merge-requests.authors"),
+			TargetBranches: viper.GetStringSlice("merge-requests.target-branches"),
+			Draft:          getOptionalBool(viper.GetViper(), "merge-requests.draft"),
+		},
+		CloneOptions:    newCloneOptions(),
+		Sources:         newSources(),
+		ConfirmPrune:    confirmPrune,
+		SelectedSources: viper.GetStringSlice(flagSource),
+	}
+	log.Trace("Core config: ", coreConfig)
+	return &coreConfig
+}
+
+// confirmPrune asks on terminal whether repos of projects deleted upstream may be removed,
+// --yes confirms without asking and nothing is removed without terminal
+func confirmPrune(paths []string) bool {
+	if viper.GetBool(flagYes) {
+		return true
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		log.Warn("Projects deleted upstream aren't pruned without confirmation, use --yes to prune them unattended")
+		return false
+	}
+
+	fmt.Fprintf(os.Stderr, "Projects not returned by API anymore:\n  %s\nRemove them? [y/N] ", strings.Join(paths, "\n  "))
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
 	// Use config file from the flag.
@@ -148,8 +328,12 @@ func initConfig() {
 	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
 	viper.AutomaticEnv()
 
-	// If a config file is found, read it in.
+	// If a config file is found, read it in, flags and environment variables are enough without it
 	err := viper.ReadInConfig()
+	if errors.Is(err, os.ErrNotExist) {
+		log.Debug("No config file: ", viper.ConfigFileUsed())
+		return
+	}
 	helpers.CheckError(err)
 	log.Debug("Using config file: ", viper.ConfigFileUsed())
 }
@@ -163,3 +347,57 @@ func initLogger() {
 	}
 	log.SetLevel(logLevel)
 }
+
+// newFilters reads filters section, v is the whole config or one of its sources
+func newFilters(v *viper.Viper) provider.Filters {
+	return provider.Filters{
+		Owned:      getOptionalBool(v, "filters.owned"),
+		Membership: getOptionalBool(v, "filters.membership"),
+		Starred:    getOptionalBool(v, "filters.starred"),
+		Archived:   getOptionalBool(v, "filters.archived"),
+		Visibility: v.GetString("filters.visibility"),
+	}
+}
+
+// newRepos reads repos section, v is the whole config or one of its sources
+func newRepos(v *viper.Viper) clone.ReposStruct {
+	return clone.ReposStruct{
+		SkipCloneStringsStruct: clone.SkipCloneStringsStruct{
+			Clone: v.GetStringSlice("repos.clone"),
+			Skip:  v.GetStringSlice("repos.skip"),
+		},
+		Topics:           v.GetStringSlice("repos.topics"),
+		Archived:         getOptionalBool(v, "repos.archived"),
+		Visibility:       v.GetStringSlice("repos.visibility"),
+		ActiveWithinDays: v.GetInt("repos.active-within-days"),
+		EmptyRepo:        getOptionalBool(v, "repos.empty-repo"),
+		Forks:            getOptionalBool(v, "repos.forks"),
+		NamespaceKind:    v.GetString("repos.namespace-kind"),
+	}
+}
+
+// newBranches reads branches section, v is the whole config or one of its sources
+func newBranches(v *viper.Viper) clone.BranchesStruct {
+	return clone.BranchesStruct{
+		Prefix: v.GetString("branches.prefix"),
+		Suffix: v.GetString("branches.suffix"),
+		Slash:  v.GetString("branches.slash"),
+		SkipCloneStringsStruct: clone.SkipCloneStringsStruct{
+			Clone: v.GetStringSlice("branches.clone"),
+			Skip:  v.GetStringSlice("branches.skip"),
+		},
+		ProtectedOnly:    v.GetBool("branches.protected-only"),
+		MaxAgeDays:       v.GetInt("branches.max-age-days"),
+		OpenMergeRequest: v.GetBool("branches.open-merge-request"),
+		Latest:           v.GetInt("branches.latest"),
+	}
+}
+
+// getOptionalBool returns nil when key is set neither in config nor in environment
+func getOptionalBool(v *viper.Viper, key string) *bool {
+	if !v.IsSet(key) {
+		return nil
+	}
+	value := v.GetBool(key)
+	return &value
+}
@@ -0,0 +1,47 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cmd
+
+import (
+	"os"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestConfirmPruneWithoutTerminal(t *testing.T) {
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+	savedStdin := os.Stdin
+	defer func() { os.Stdin = savedStdin }()
+	os.Stdin = devNull
+
+	viper.Set(flagYes, false)
+	defer viper.Set(flagYes, nil)
+	if confirmPrune([]string{"group/deleted"}) {
+		t.Error("prune is confirmed without terminal")
+	}
+
+	viper.Set(flagYes, true)
+	if !confirmPrune([]string{"group/deleted"}) {
+		t.Error("prune isn't confirmed by --yes")
+	}
+}
@@ -0,0 +1,97 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cmd
+
+import (
+	"github.com/Logunov/heydevops/clone"
+	"github.com/Logunov/heydevops/helpers"
+	"github.com/spf13/viper"
+)
+
+// sourceKeys are keys of every item of sources list, sections replace top level ones as a whole
+var sourceKeys = map[string]string{
+	"name":           typeString,
+	"provider":       typeString,
+	flagGitlabURL:    typeString,
+	flagGitlabAPIURL: typeString,
+	flagToken:        typeString,
+	flagGroups:       typeStrings,
+	"subdir":         typeString,
+	"filters":        "",
+	"repos":          "",
+	"branches":       "",
+}
+
+// newSources reads sources list of config file, every source is read by its own viper
+// with the same functions as the top level config
+func newSources() []*clone.SourceStruct {
+	items, _ := viper.Get("sources").([]interface{})
+
+	var sources []*clone.SourceStruct
+	for _, item := range items {
+		values, _ := item.(map[string]interface{})
+		v := viper.New()
+		helpers.CheckDebug(v.MergeConfigMap(values))
+
+		source := &clone.SourceStruct{
+			Name:         v.GetString("name"),
+			Provider:     v.GetString("provider"),
+			GitLabURL:    v.GetString(flagGitlabURL),
+			GitLabAPIURL: v.GetString(flagGitlabAPIURL),
+			Token:        helpers.Secret(v.GetString(flagToken)),
+			Subdir:       v.GetString("subdir"),
+		}
+		if v.IsSet(flagGroups) {
+			source.Groups = v.GetStringSlice(flagGroups)
+		}
+		if v.IsSet("filters") {
+			filters := newFilters(v)
+			source.Filters = &filters
+		}
+		if v.IsSet("repos") {
+			repos := newRepos(v)
+			source.Repos = &repos
+		}
+		if v.IsSet("branches") {
+			branches := newBranches(v)
+			source.Branches = &branches
+		}
+		sources = append(sources, source)
+	}
+	return sources
+}
+
+// newCloneOptions reads clone options rules of config file
+func newCloneOptions() []*clone.CloneOptionsStruct {
+	items, _ := viper.Get("clone-options").([]interface{})
+
+	var rules []*clone.CloneOptionsStruct
+	for _, item := range items {
+		values, _ := item.(map[string]interface{})
+		v := viper.New()
+		helpers.CheckDebug(v.MergeConfigMap(values))
+
+		rules = append(rules, &clone.CloneOptionsStruct{
+			Repos:  v.GetStringSlice("repos"),
+			Filter: v.GetString("filter"),
+			Depth:  v.GetInt("depth"),
+			Sparse: v.GetStringSlice("sparse"),
+		})
+	}
+	return rules
+}
@@ -0,0 +1,62 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/Logunov/heydevops/clone"
+	"github.com/Logunov/heydevops/helpers"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// statusCmd represents the status command
+var (
+	statusCmd = &cobra.Command{
+		Use:   "status",
+		Short: "Shows status of every cloned repo and branch worktree",
+		Long: `Walks the local layout and reports for every submodule, clone and worktree:
+uncommitted changes, commits ahead of or behind upstream, detached HEAD, missing upstream
+and stale worktrees. Use --output json or yaml for machine-readable output.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			initCore(cmd, true)
+
+			statuses, err := clone.Status()
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			if output := viper.GetString(flagOutput); output != "" {
+				helpers.CheckError(clone.WriteOutput(os.Stdout, output, statuses))
+			} else {
+				clone.PrintStatus(os.Stdout, statuses)
+			}
+
+			for _, status := range statuses {
+				if status.Error != "" {
+					os.Exit(1)
+				}
+			}
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+}
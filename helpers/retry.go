@@ -0,0 +1,90 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package helpers
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RetryConfig sets retries of failed operations up, delays grow exponentially from Delay
+// up to MaxDelay with random jitter
+type RetryConfig struct {
+	Attempts int
+	Delay    time.Duration
+	MaxDelay time.Duration
+	// OnRetry is called before every retry
+	OnRetry func(what string, attempt int, delay time.Duration, err error)
+}
+
+// Do runs operation until it succeeds, its error isn't retryable or attempts are over.
+// retryable may return delay requested by the server, it is used if it is longer than the backoff.
+func (r *RetryConfig) Do(what string, retryable func(error) (bool, time.Duration), operation func() error) error {
+	attempts := 1
+	if r != nil && r.Attempts > 1 {
+		attempts = r.Attempts
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := operation()
+		if err == nil {
+			return nil
+		}
+		retry, serverDelay := retryable(err)
+		if !retry || attempt >= attempts {
+			if attempt > 1 {
+				return fmt.Errorf("%w (%d attempts)", err, attempt)
+			}
+			return err
+		}
+
+		delay := r.backoff(attempt)
+		if serverDelay > delay {
+			delay = serverDelay
+		}
+		log.WithFields(logrus.Fields{
+			"attempt": attempt,
+			"delay":   delay,
+			"err":     err,
+			"what":    what,
+		}).Debug("retrying")
+		if r.OnRetry != nil {
+			r.OnRetry(what, attempt, delay, err)
+		}
+		time.Sleep(delay)
+	}
+}
+
+// backoff returns delay before retry after attempt, it is random between half and full
+// exponential delay so parallel workers don't retry at once
+func (r *RetryConfig) backoff(attempt int) time.Duration {
+	delay := r.Delay
+	for i := 1; i < attempt && delay < r.MaxDelay; i++ {
+		delay *= 2
+	}
+	if r.MaxDelay > 0 && delay > r.MaxDelay {
+		delay = r.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
@@ -0,0 +1,90 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package helpers
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		retry   RetryConfig
+		attempt int
+		full    time.Duration
+	}{
+		{"first", RetryConfig{Delay: time.Second, MaxDelay: time.Minute}, 1, time.Second},
+		{"doubled", RetryConfig{Delay: time.Second, MaxDelay: time.Minute}, 3, 4 * time.Second},
+		{"capped", RetryConfig{Delay: time.Second, MaxDelay: 10 * time.Second}, 10, 10 * time.Second},
+		{"capped below delay", RetryConfig{Delay: time.Minute, MaxDelay: time.Second}, 1, time.Second},
+		{"no delay", RetryConfig{}, 5, 0},
+	}
+	for _, test := range tests {
+		for i := 0; i < 100; i++ {
+			got := test.retry.backoff(test.attempt)
+			if got < test.full/2 || got > test.full {
+				t.Fatalf("%s: backoff(%d) is %s, want between %s and %s", test.name, test.attempt, got, test.full/2, test.full)
+			}
+		}
+	}
+}
+
+func TestDo(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	SetLogger(logger)
+	t.Cleanup(func() { SetLogger(nil) })
+
+	transient, fatal := errors.New("transient"), errors.New("fatal")
+	tests := []struct {
+		name     string
+		attempts int
+		errs     []error
+		calls    int
+		err      error
+	}{
+		{"succeeds", 3, nil, 1, nil},
+		{"retried", 3, []error{transient, transient}, 3, nil},
+		{"attempts are over", 2, []error{transient, transient, transient}, 2, transient},
+		{"not retryable", 3, []error{fatal, transient}, 1, fatal},
+	}
+	for _, test := range tests {
+		retry := &RetryConfig{Attempts: test.attempts, Delay: time.Millisecond}
+		var retries int
+		retry.OnRetry = func(string, int, time.Duration, error) { retries++ }
+
+		calls := 0
+		err := retry.Do(test.name, func(err error) (bool, time.Duration) {
+			return errors.Is(err, transient), 0
+		}, func() error {
+			calls++
+			if calls <= len(test.errs) {
+				return test.errs[calls-1]
+			}
+			return nil
+		})
+		if calls != test.calls || retries != calls-1 || !errors.Is(err, test.err) {
+			t.Errorf("%s: %d calls, %d retries, error %v, want %d calls, error %v", test.name, calls, retries, err, test.calls, test.err)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package helpers
+
+import "fmt"
+
+const redacted = "[REDACTED]"
+
+// Secret is a string which is never printed, formatting it with any verb gives redacted placeholder
+type Secret string
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+func (s Secret) GoString() string {
+	return s.String()
+}
+
+// Format redacts the secret for verbs which don't use String like %d or %x
+func (s Secret) Format(state fmt.State, verb rune) {
+	_, _ = fmt.Fprint(state, s.String())
+}
+
+// Reveal returns the secret value, it must not be logged
+func (s Secret) Reveal() string {
+	return string(s)
+}
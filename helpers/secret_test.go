@@ -0,0 +1,64 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package helpers
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestSecretRedacted(t *testing.T) {
+	secret := Secret("glpat-secret")
+	config := struct {
+		URL   string
+		Token Secret
+	}{"https://gitlab.com/", secret}
+
+	for _, format := range []string{"%s", "%v", "%+v", "%#v", "%q", "%x", "%d", "%10s"} {
+		for _, value := range []interface{}{secret, config, &config} {
+			if output := fmt.Sprintf(format, value); strings.Contains(output, "secret") || !strings.Contains(output, redacted) {
+				t.Errorf("Sprintf(%q) = %q", format, output)
+			}
+		}
+	}
+	if output := fmt.Sprint("token: ", secret); output != "token: "+redacted {
+		t.Errorf("Sprint() = %q", output)
+	}
+
+	var buffer bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&buffer)
+	logger.SetLevel(logrus.TraceLevel)
+	logger.Trace("Config Token: ", secret)
+	logger.WithField("token", secret).Trace("token field")
+	logger.Trace("Core config: ", config)
+	if strings.Contains(buffer.String(), "secret") {
+		t.Errorf("token is logged: %s", buffer.String())
+	}
+
+	if secret.Reveal() != "glpat-secret" {
+		t.Errorf("Reveal() = %q", secret.Reveal())
+	}
+	if Secret("").String() != "" {
+		t.Error("empty secret must be printed empty, it tells token isn't set")
+	}
+}
@@ -0,0 +1,289 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package provider
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type giteaProvider struct {
+	restClient
+	apiURL  string
+	perPage int
+	token   string
+	groups  []string
+	filters Filters
+}
+
+type giteaRepo struct {
+	ID            int        `json:"id"`
+	Name          string     `json:"name"`
+	FullName      string     `json:"full_name"`
+	HTMLURL       string     `json:"html_url"`
+	SSHURL        string     `json:"ssh_url"`
+	CloneURL      string     `json:"clone_url"`
+	DefaultBranch string     `json:"default_branch"`
+	Archived      bool       `json:"archived"`
+	HasWiki       bool       `json:"has_wiki"`
+	Private       bool       `json:"private"`
+	Internal      bool       `json:"internal"`
+	UpdatedAt     *time.Time `json:"updated_at"`
+	Topics        []string   `json:"topics"`
+	Empty         bool       `json:"empty"`
+	Fork          bool       `json:"fork"`
+	Owner         struct {
+		Login string `json:"login"`
+	} `json:"owner"`
+}
+
+type giteaSearchResult struct {
+	OK   bool        `json:"ok"`
+	Data []giteaRepo `json:"data"`
+}
+
+type giteaBranch struct {
+	Name      string `json:"name"`
+	Protected bool   `json:"protected"`
+	Commit    struct {
+		ID        string     `json:"id"`
+		Timestamp *time.Time `json:"timestamp"`
+	} `json:"commit"`
+}
+
+type giteaTag struct {
+	Name   string `json:"name"`
+	Commit struct {
+		SHA string `json:"sha"`
+	} `json:"commit"`
+}
+
+type giteaPull struct {
+	Number  int    `json:"number"`
+	Title   string `json:"title"`
+	HTMLURL string `json:"html_url"`
+	Draft   bool   `json:"draft"`
+	User    struct {
+		Login string `json:"login"`
+	} `json:"user"`
+	Labels []struct {
+		Name string `json:"name"`
+	} `json:"labels"`
+	Head giteaPullRef `json:"head"`
+	Base giteaPullRef `json:"base"`
+}
+
+type giteaPullRef struct {
+	SHA    string `json:"sha"`
+	Ref    string `json:"ref"`
+	RepoID int    `json:"repo_id"`
+}
+
+func newGitea(config Config) (Provider, error) {
+	apiURL := config.APIURL
+	if apiURL == "" || apiURL == config.URL {
+		apiURL = strings.TrimSuffix(config.URL, "/") + "/api/v1/"
+	}
+	return &giteaProvider{
+		restClient: restClient{
+			httpClient:    config.HTTPClient,
+			retry:         config.Retry,
+			authorization: "token " + config.Token,
+		},
+		apiURL:  strings.TrimSuffix(apiURL, "/") + "/",
+		perPage: config.PerPage,
+		token:   config.Token,
+		groups:  config.Groups,
+		filters: config.Filters,
+	}, nil
+}
+
+// ListProjects lists repositories of organizations from groups or all visible with the token
+func (p *giteaProvider) ListProjects(projects chan<- *Project) error {
+	if len(p.groups) == 0 {
+		for page := 1; ; page++ {
+			var result giteaSearchResult
+			if _, err := p.getJSON(fmt.Sprintf("%srepos/search?limit=%d&page=%d", p.apiURL, p.perPage, page), &result); err != nil {
+				return err
+			}
+			if len(result.Data) == 0 {
+				return nil
+			}
+			p.sendRepos(result.Data, projects)
+		}
+	}
+
+	for _, group := range p.groups {
+		for page := 1; ; page++ {
+			var repos []giteaRepo
+			if _, err := p.getJSON(fmt.Sprintf("%sorgs/%s/repos?limit=%d&page=%d", p.apiURL, url.PathEscape(group), p.perPage, page), &repos); err != nil {
+				return fmt.Errorf("organization %s: %w", group, err)
+			}
+			if len(repos) == 0 {
+				break
+			}
+			p.sendRepos(repos, projects)
+		}
+	}
+	return nil
+}
+
+func (p *giteaProvider) sendRepos(repos []giteaRepo, projects chan<- *Project) {
+	for i := range repos {
+		if project := newGiteaProject(&repos[i]); p.filters.match(project) {
+			projects <- project
+		}
+	}
+}
+
+func (p *giteaProvider) ListBranches(project *Project) ([]*Branch, error) {
+	var branches []*Branch
+
+	for page := 1; ; page++ {
+		var giteaBranches []giteaBranch
+		branchesURL := fmt.Sprintf("%srepos/%s/branches?limit=%d&page=%d", p.apiURL, escapeFullName(project.PathWithNamespace), p.perPage, page)
+		if _, err := p.getJSON(branchesURL, &giteaBranches); err != nil {
+			return nil, err
+		}
+		if len(giteaBranches) == 0 {
+			return branches, nil
+		}
+		for _, giteaBranch := range giteaBranches {
+			branches = append(branches, &Branch{
+				Name:        giteaBranch.Name,
+				Default:     giteaBranch.Name == project.DefaultBranch,
+				Protected:   giteaBranch.Protected,
+				CommitID:    giteaBranch.Commit.ID,
+				CommittedAt: giteaBranch.Commit.Timestamp,
+			})
+		}
+	}
+}
+
+func (p *giteaProvider) GetBranch(project *Project, name string) (*Branch, error) {
+	var giteaBranch giteaBranch
+	branchURL := fmt.Sprintf("%srepos/%s/branches/%s", p.apiURL, escapeFullName(project.PathWithNamespace), escapeFullName(name))
+	if _, err := p.getJSON(branchURL, &giteaBranch); err != nil {
+		return nil, err
+	}
+	return &Branch{
+		Name:        giteaBranch.Name,
+		Default:     giteaBranch.Name == project.DefaultBranch,
+		Protected:   giteaBranch.Protected,
+		CommitID:    giteaBranch.Commit.ID,
+		CommittedAt: giteaBranch.Commit.Timestamp,
+	}, nil
+}
+
+func (p *giteaProvider) ListTags(project *Project) ([]*Tag, error) {
+	var tags []*Tag
+
+	for page := 1; ; page++ {
+		var giteaTags []giteaTag
+		tagsURL := fmt.Sprintf("%srepos/%s/tags?limit=%d&page=%d", p.apiURL, escapeFullName(project.PathWithNamespace), p.perPage, page)
+		if _, err := p.getJSON(tagsURL, &giteaTags); err != nil {
+			return nil, err
+		}
+		if len(giteaTags) == 0 {
+			return tags, nil
+		}
+		for _, giteaTag := range giteaTags {
+			tags = append(tags, &Tag{Name: giteaTag.Name, CommitID: giteaTag.Commit.SHA})
+		}
+	}
+}
+
+func (p *giteaProvider) ListMergeRequests(project *Project) ([]*MergeRequest, error) {
+	var mergeRequests []*MergeRequest
+
+	for page := 1; ; page++ {
+		var giteaPulls []giteaPull
+		pullsURL := fmt.Sprintf("%srepos/%s/pulls?state=open&limit=%d&page=%d", p.apiURL, escapeFullName(project.PathWithNamespace), p.perPage, page)
+		if _, err := p.getJSON(pullsURL, &giteaPulls); err != nil {
+			return nil, err
+		}
+		if len(giteaPulls) == 0 {
+			return mergeRequests, nil
+		}
+		for i := range giteaPulls {
+			mergeRequests = append(mergeRequests, newGiteaMergeRequest(&giteaPulls[i]))
+		}
+	}
+}
+
+func (p *giteaProvider) CloneURL(project *Project, protocol string) (string, error) {
+	return cloneURL(project, protocol)
+}
+
+func (p *giteaProvider) Credentials() (string, string) {
+	// Gitea accepts token as username with any password
+	return p.token, "x-oauth-basic"
+}
+
+func newGiteaProject(repo *giteaRepo) *Project {
+	return &Project{
+		ID:                fmt.Sprint(repo.ID),
+		Name:              repo.Name,
+		Namespace:         repo.Owner.Login,
+		PathWithNamespace: repo.FullName,
+		WebURL:            repo.HTMLURL,
+		SSHURL:            repo.SSHURL,
+		HTTPURL:           repo.CloneURL,
+		DefaultBranch:     repo.DefaultBranch,
+		Archived:          repo.Archived,
+		WikiEnabled:       repo.HasWiki,
+		Visibility:        giteaVisibility(repo),
+		LastActivityAt:    repo.UpdatedAt,
+		Topics:            repo.Topics,
+		EmptyRepo:         repo.Empty,
+		Fork:              repo.Fork,
+	}
+}
+
+func newGiteaMergeRequest(pull *giteaPull) *MergeRequest {
+	mergeRequest := &MergeRequest{
+		IID:          pull.Number,
+		Title:        pull.Title,
+		WebURL:       pull.HTMLURL,
+		SourceBranch: pull.Head.Ref,
+		TargetBranch: pull.Base.Ref,
+		Author:       pull.User.Login,
+		Ref:          fmt.Sprintf("refs/pull/%d/head", pull.Number),
+		CommitID:     pull.Head.SHA,
+		// Older Gitea has no draft flag, work in progress is marked in the title
+		Draft: pull.Draft || strings.HasPrefix(pull.Title, "WIP:") || strings.HasPrefix(pull.Title, "Draft:"),
+		Fork:  pull.Head.RepoID != pull.Base.RepoID,
+	}
+	for _, label := range pull.Labels {
+		mergeRequest.Labels = append(mergeRequest.Labels, label.Name)
+	}
+	return mergeRequest
+}
+
+func giteaVisibility(repo *giteaRepo) string {
+	switch {
+	case repo.Private:
+		return "private"
+	case repo.Internal:
+		return "internal"
+	default:
+		return "public"
+	}
+}
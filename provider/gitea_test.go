@@ -0,0 +1,148 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package provider
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+// pageItems writes page of items selected with Gitea page and limit parameters, past the last page it is empty
+func pageItems(writer http.ResponseWriter, request *http.Request, items []map[string]interface{}, wrap bool) {
+	query := request.URL.Query()
+	page, _ := strconv.Atoi(query.Get("page"))
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	start, end := (page-1)*limit, page*limit
+	if start > len(items) {
+		start = len(items)
+	}
+	if end > len(items) {
+		end = len(items)
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	if wrap {
+		_ = json.NewEncoder(writer).Encode(map[string]interface{}{"ok": true, "data": items[start:end]})
+		return
+	}
+	_ = json.NewEncoder(writer).Encode(items[start:end])
+}
+
+func giteaReposJSON(count int) []map[string]interface{} {
+	var repos []map[string]interface{}
+	for id := 1; id <= count; id++ {
+		name := "repo" + strconv.Itoa(id)
+		repos = append(repos, map[string]interface{}{
+			"id":        id,
+			"name":      name,
+			"full_name": "org/" + name,
+			"private":   id%3 == 0,
+			"owner":     map[string]interface{}{"login": "org"},
+		})
+	}
+	return repos
+}
+
+func TestGiteaSearch(t *testing.T) {
+	hosting := newTestProvider(t, func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path != "/api/repos/search" || request.Header.Get("Authorization") != "token token" {
+			t.Errorf("unexpected request %s", request.URL)
+		}
+		pageItems(writer, request, giteaReposJSON(7), true)
+	}, Config{Kind: Gitea})
+
+	checkIDs(t, "search", listProjectIDs(t, hosting), 7)
+}
+
+// TestGiteaOrgs checks organizations listing and visibility filter applied by the provider
+func TestGiteaOrgs(t *testing.T) {
+	hosting := newTestProvider(t, func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path != "/api/orgs/org/repos" {
+			t.Errorf("unexpected request %s", request.URL)
+		}
+		pageItems(writer, request, giteaReposJSON(7), false)
+	}, Config{Kind: Gitea, Groups: []string{"org"}, Filters: Filters{Visibility: "private"}})
+
+	ids := listProjectIDs(t, hosting)
+	if len(ids) != 2 || ids[0] != 3 || ids[1] != 6 {
+		t.Errorf("listed %v, want private [3 6]", ids)
+	}
+}
+
+func TestGiteaBranches(t *testing.T) {
+	hosting := newTestProvider(t, func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path != "/api/repos/org/repo1/branches" {
+			t.Errorf("unexpected request %s", request.URL)
+		}
+		var branches []map[string]interface{}
+		for _, name := range []string{"a", "b", "c", "main"} {
+			branches = append(branches, map[string]interface{}{
+				"name":   name,
+				"commit": map[string]interface{}{"id": "sha-" + name, "timestamp": "2024-01-01T00:00:00Z"},
+			})
+		}
+		pageItems(writer, request, branches, false)
+	}, Config{Kind: Gitea})
+
+	branches, err := hosting.ListBranches(&Project{PathWithNamespace: "org/repo1", DefaultBranch: "main"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(branches) != 4 || !branches[3].Default || branches[3].CommittedAt == nil {
+		t.Errorf("branches are %+v", branches)
+	}
+}
+
+func TestGiteaResponseError(t *testing.T) {
+	hosting := newTestProvider(t, func(writer http.ResponseWriter, request *http.Request) {
+		http.Error(writer, "not found", http.StatusNotFound)
+	}, Config{Kind: Gitea, Groups: []string{"missing"}})
+
+	_, err := listProjects(hosting)
+	var responseError *ResponseError
+	if !errors.As(err, &responseError) || responseError.StatusCode != http.StatusNotFound {
+		t.Fatalf("error is %v, want 404 response", err)
+	}
+	if retry, _ := retryable(err); retry {
+		t.Errorf("404 is retried")
+	}
+}
+
+func TestGiteaGetBranch(t *testing.T) {
+	hosting := newTestProvider(t, func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path != "/api/repos/org/repo1/branches/main" {
+			t.Errorf("unexpected request %s", request.URL)
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(writer).Encode(map[string]interface{}{
+			"name":   "main",
+			"commit": map[string]interface{}{"id": "sha-main", "timestamp": "2024-01-01T00:00:00Z"},
+		})
+	}, Config{Kind: Gitea})
+
+	branch, err := hosting.GetBranch(&Project{PathWithNamespace: "org/repo1", DefaultBranch: "main"}, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !branch.Default || branch.CommitID != "sha-main" || branch.CommittedAt == nil {
+		t.Errorf("branch is %+v", branch)
+	}
+}
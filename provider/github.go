@@ -0,0 +1,280 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package provider
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const gitHubDefaultAPIURL = "https://api.github.com/"
+
+// GitHubURL is the address of GitHub, local paths are repository web URLs with it stripped
+const GitHubURL = "https://github.com/"
+
+type gitHubProvider struct {
+	restClient
+	apiURL  string
+	perPage int
+	token   string
+	groups  []string
+	filters Filters
+}
+
+type gitHubRepo struct {
+	ID            int        `json:"id"`
+	Name          string     `json:"name"`
+	FullName      string     `json:"full_name"`
+	HTMLURL       string     `json:"html_url"`
+	SSHURL        string     `json:"ssh_url"`
+	CloneURL      string     `json:"clone_url"`
+	DefaultBranch string     `json:"default_branch"`
+	Archived      bool       `json:"archived"`
+	HasWiki       bool       `json:"has_wiki"`
+	Visibility    string     `json:"visibility"`
+	PushedAt      *time.Time `json:"pushed_at"`
+	Topics        []string   `json:"topics"`
+	Fork          bool       `json:"fork"`
+	Size          int        `json:"size"`
+	Owner         struct {
+		Login string `json:"login"`
+		Type  string `json:"type"`
+	} `json:"owner"`
+}
+
+type gitHubPull struct {
+	Number  int    `json:"number"`
+	Title   string `json:"title"`
+	HTMLURL string `json:"html_url"`
+	Draft   bool   `json:"draft"`
+	User    struct {
+		Login string `json:"login"`
+	} `json:"user"`
+	Labels []struct {
+		Name string `json:"name"`
+	} `json:"labels"`
+	Head gitHubPullRef `json:"head"`
+	Base gitHubPullRef `json:"base"`
+}
+
+type gitHubPullRef struct {
+	SHA  string `json:"sha"`
+	Ref  string `json:"ref"`
+	Repo *struct {
+		FullName string `json:"full_name"`
+	} `json:"repo"`
+}
+
+// gitHubBranch is a branch or a tag, they have the same name and commit fields
+type gitHubBranch struct {
+	Name      string `json:"name"`
+	Protected bool   `json:"protected"`
+	Commit    struct {
+		SHA string `json:"sha"`
+	} `json:"commit"`
+}
+
+func newGitHub(config Config) (Provider, error) {
+	apiURL := config.APIURL
+	if apiURL == "" || apiURL == config.URL {
+		apiURL = gitHubDefaultAPIURL
+	}
+	return &gitHubProvider{
+		restClient: restClient{
+			httpClient:    config.HTTPClient,
+			retry:         config.Retry,
+			authorization: "Bearer " + config.Token,
+		},
+		apiURL:  strings.TrimSuffix(apiURL, "/") + "/",
+		perPage: config.PerPage,
+		token:   config.Token,
+		groups:  config.Groups,
+		filters: config.Filters,
+	}, nil
+}
+
+// ListProjects lists repositories of organizations from groups or of the token user
+func (p *gitHubProvider) ListProjects(projects chan<- *Project) error {
+	if len(p.groups) == 0 {
+		return p.listRepos(fmt.Sprintf("%suser/repos?per_page=%d", p.apiURL, p.perPage), projects)
+	}
+	for _, group := range p.groups {
+		if err := p.listRepos(fmt.Sprintf("%sorgs/%s/repos?per_page=%d", p.apiURL, url.PathEscape(group), p.perPage), projects); err != nil {
+			return fmt.Errorf("organization %s: %w", group, err)
+		}
+	}
+	return nil
+}
+
+func (p *gitHubProvider) listRepos(next string, projects chan<- *Project) error {
+	for next != "" {
+		var repos []gitHubRepo
+		var err error
+		if next, err = p.getJSON(next, &repos); err != nil {
+			return err
+		}
+		for i := range repos {
+			if project := newGitHubProject(&repos[i]); p.filters.match(project) {
+				projects <- project
+			}
+		}
+	}
+	return nil
+}
+
+func (p *gitHubProvider) ListBranches(project *Project) ([]*Branch, error) {
+	var branches []*Branch
+
+	next := fmt.Sprintf("%srepos/%s/branches?per_page=%d", p.apiURL, escapeFullName(project.PathWithNamespace), p.perPage)
+	for next != "" {
+		var gitHubBranches []gitHubBranch
+		var err error
+		if next, err = p.getJSON(next, &gitHubBranches); err != nil {
+			return nil, err
+		}
+		for _, gitHubBranch := range gitHubBranches {
+			branches = append(branches, &Branch{
+				Name:      gitHubBranch.Name,
+				Default:   gitHubBranch.Name == project.DefaultBranch,
+				Protected: gitHubBranch.Protected,
+				CommitID:  gitHubBranch.Commit.SHA,
+			})
+		}
+	}
+	return branches, nil
+}
+
+func (p *gitHubProvider) GetBranch(project *Project, name string) (*Branch, error) {
+	var gitHubBranch gitHubBranch
+	branchURL := fmt.Sprintf("%srepos/%s/branches/%s", p.apiURL, escapeFullName(project.PathWithNamespace), escapeFullName(name))
+	if _, err := p.getJSON(branchURL, &gitHubBranch); err != nil {
+		return nil, err
+	}
+	return &Branch{
+		Name:      gitHubBranch.Name,
+		Default:   gitHubBranch.Name == project.DefaultBranch,
+		Protected: gitHubBranch.Protected,
+		CommitID:  gitHubBranch.Commit.SHA,
+	}, nil
+}
+
+func (p *gitHubProvider) ListTags(project *Project) ([]*Tag, error) {
+	var tags []*Tag
+
+	next := fmt.Sprintf("%srepos/%s/tags?per_page=%d", p.apiURL, escapeFullName(project.PathWithNamespace), p.perPage)
+	for next != "" {
+		var gitHubTags []gitHubBranch
+		var err error
+		if next, err = p.getJSON(next, &gitHubTags); err != nil {
+			return nil, err
+		}
+		for _, gitHubTag := range gitHubTags {
+			tags = append(tags, &Tag{Name: gitHubTag.Name, CommitID: gitHubTag.Commit.SHA})
+		}
+	}
+	return tags, nil
+}
+
+func (p *gitHubProvider) ListMergeRequests(project *Project) ([]*MergeRequest, error) {
+	var mergeRequests []*MergeRequest
+
+	next := fmt.Sprintf("%srepos/%s/pulls?state=open&per_page=%d", p.apiURL, escapeFullName(project.PathWithNamespace), p.perPage)
+	for next != "" {
+		var gitHubPulls []gitHubPull
+		var err error
+		if next, err = p.getJSON(next, &gitHubPulls); err != nil {
+			return nil, err
+		}
+		for i := range gitHubPulls {
+			mergeRequests = append(mergeRequests, newGitHubMergeRequest(&gitHubPulls[i]))
+		}
+	}
+	return mergeRequests, nil
+}
+
+func (p *gitHubProvider) CloneURL(project *Project, protocol string) (string, error) {
+	return cloneURL(project, protocol)
+}
+
+func (p *gitHubProvider) Credentials() (string, string) {
+	return "x-access-token", p.token
+}
+
+func newGitHubProject(repo *gitHubRepo) *Project {
+	return &Project{
+		ID:                fmt.Sprint(repo.ID),
+		Name:              repo.Name,
+		Namespace:         repo.Owner.Login,
+		PathWithNamespace: repo.FullName,
+		WebURL:            repo.HTMLURL,
+		SSHURL:            repo.SSHURL,
+		HTTPURL:           repo.CloneURL,
+		DefaultBranch:     repo.DefaultBranch,
+		Archived:          repo.Archived,
+		WikiEnabled:       repo.HasWiki,
+		Visibility:        repo.Visibility,
+		LastActivityAt:    repo.PushedAt,
+		Topics:            repo.Topics,
+		EmptyRepo:         repo.Size == 0,
+		Fork:              repo.Fork,
+		NamespaceKind:     gitHubNamespaceKind(repo),
+	}
+}
+
+// gitHubNamespaceKind maps owner type, organizations are groups
+func gitHubNamespaceKind(repo *gitHubRepo) string {
+	switch repo.Owner.Type {
+	case "User":
+		return NamespaceUser
+	case "Organization":
+		return NamespaceGroup
+	default:
+		return ""
+	}
+}
+
+func newGitHubMergeRequest(pull *gitHubPull) *MergeRequest {
+	mergeRequest := &MergeRequest{
+		IID:          pull.Number,
+		Title:        pull.Title,
+		WebURL:       pull.HTMLURL,
+		SourceBranch: pull.Head.Ref,
+		TargetBranch: pull.Base.Ref,
+		Author:       pull.User.Login,
+		Ref:          fmt.Sprintf("refs/pull/%d/head", pull.Number),
+		CommitID:     pull.Head.SHA,
+		Draft:        pull.Draft,
+		// Head repository is null when the fork was deleted
+		Fork: pull.Head.Repo == nil || pull.Base.Repo == nil || pull.Head.Repo.FullName != pull.Base.Repo.FullName,
+	}
+	for _, label := range pull.Labels {
+		mergeRequest.Labels = append(mergeRequest.Labels, label.Name)
+	}
+	return mergeRequest
+}
+
+// escapeFullName escapes every element of "owner/repo" keeping slashes
+func escapeFullName(fullName string) string {
+	elements := strings.Split(fullName, "/")
+	for i, element := range elements {
+		elements[i] = url.PathEscape(element)
+	}
+	return strings.Join(elements, "/")
+}
@@ -0,0 +1,173 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package provider
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+// linkPage writes page of items with GitHub Link header pointing to the next one
+func linkPage(writer http.ResponseWriter, request *http.Request, items []map[string]interface{}) {
+	page, _ := strconv.Atoi(request.URL.Query().Get("page"))
+	if page == 0 {
+		page = 1
+	}
+	start, end := (page-1)*3, page*3
+	if end < len(items) {
+		query := request.URL.Query()
+		query.Set("page", strconv.Itoa(page+1))
+		writer.Header().Set("Link", fmt.Sprintf(`<http://%s%s?%s>; rel="next", <http://%s/last>; rel="last"`,
+			request.Host, request.URL.Path, query.Encode(), request.Host))
+	} else {
+		end = len(items)
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(writer).Encode(items[start:end])
+}
+
+func gitHubReposJSON(count int) []map[string]interface{} {
+	var repos []map[string]interface{}
+	for id := 1; id <= count; id++ {
+		name := "repo" + strconv.Itoa(id)
+		repos = append(repos, map[string]interface{}{
+			"id":        id,
+			"name":      name,
+			"full_name": "org/" + name,
+			"html_url":  "https://github.com/org/" + name,
+			"archived":  id%2 == 0,
+			"owner":     map[string]interface{}{"login": "org", "type": "Organization"},
+		})
+	}
+	return repos
+}
+
+func TestGitHubRepos(t *testing.T) {
+	hosting := newTestProvider(t, func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path != "/api/user/repos" || request.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("unexpected request %s", request.URL)
+		}
+		linkPage(writer, request, gitHubReposJSON(8))
+	}, Config{Kind: GitHub})
+
+	checkIDs(t, "user repos", listProjectIDs(t, hosting), 8)
+}
+
+// TestGitHubOrgs checks organizations listing and filters applied by the provider
+func TestGitHubOrgs(t *testing.T) {
+	hosting := newTestProvider(t, func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path != "/api/orgs/org/repos" {
+			t.Errorf("unexpected request %s", request.URL)
+		}
+		linkPage(writer, request, gitHubReposJSON(7))
+	}, Config{Kind: GitHub, Groups: []string{"org"}, Filters: Filters{Archived: boolPtr(false)}})
+
+	projects, err := listProjects(hosting)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(projects) != 4 {
+		t.Errorf("listed %d projects, want 4 not archived", len(projects))
+	}
+	for _, project := range projects {
+		if project.Archived || project.Namespace != "org" || project.NamespaceKind != NamespaceGroup {
+			t.Errorf("project is %+v", project)
+		}
+	}
+}
+
+func TestGitHubBranchesAndPulls(t *testing.T) {
+	hosting := newTestProvider(t, func(writer http.ResponseWriter, request *http.Request) {
+		switch request.URL.Path {
+		case "/api/repos/org/repo1/branches":
+			var branches []map[string]interface{}
+			for _, name := range []string{"a", "b", "c", "main"} {
+				branches = append(branches, map[string]interface{}{
+					"name":      name,
+					"protected": name == "main",
+					"commit":    map[string]interface{}{"sha": "sha-" + name},
+				})
+			}
+			linkPage(writer, request, branches)
+		case "/api/repos/org/repo1/pulls":
+			fork := map[string]interface{}{"full_name": "someone/repo1"}
+			base := map[string]interface{}{"full_name": "org/repo1"}
+			linkPage(writer, request, []map[string]interface{}{
+				{"number": 1, "head": map[string]interface{}{"ref": "feature", "sha": "sha-f", "repo": base}, "base": map[string]interface{}{"ref": "main", "repo": base}},
+				{"number": 2, "head": map[string]interface{}{"ref": "fix", "repo": fork}, "base": map[string]interface{}{"ref": "main", "repo": base}},
+			})
+		default:
+			t.Errorf("unexpected request %s", request.URL)
+		}
+	}, Config{Kind: GitHub})
+	project := &Project{PathWithNamespace: "org/repo1", DefaultBranch: "main"}
+
+	branches, err := hosting.ListBranches(project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(branches) != 4 || !branches[3].Default || !branches[3].Protected || branches[3].CommitID != "sha-main" {
+		t.Errorf("branches are %+v", branches)
+	}
+
+	pulls, err := hosting.ListMergeRequests(project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pulls) != 2 || pulls[0].Fork || !pulls[1].Fork || pulls[0].Ref != "refs/pull/1/head" || pulls[0].CommitID != "sha-f" {
+		t.Errorf("pull requests are %+v %+v", pulls[0], pulls[1])
+	}
+}
+
+func TestGitHubResponseError(t *testing.T) {
+	hosting := newTestProvider(t, func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("X-RateLimit-Remaining", "0")
+		http.Error(writer, "rate limit exceeded", http.StatusForbidden)
+	}, Config{Kind: GitHub})
+
+	_, err := listProjects(hosting)
+	var responseError *ResponseError
+	if !errors.As(err, &responseError) || responseError.StatusCode != http.StatusForbidden || responseError.Body != "rate limit exceeded\n" {
+		t.Fatalf("error is %v, want 403 response", err)
+	}
+	if retry, _ := retryable(err); !retry {
+		t.Errorf("exhausted rate limit isn't retried")
+	}
+}
+
+func TestGitHubGetBranch(t *testing.T) {
+	hosting := newTestProvider(t, func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path != "/api/repos/org/repo1/branches/feature/a" {
+			t.Errorf("unexpected request %s", request.URL)
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(writer).Encode(map[string]interface{}{"name": "feature/a", "commit": map[string]interface{}{"sha": "sha-a"}})
+	}, Config{Kind: GitHub})
+
+	branch, err := hosting.GetBranch(&Project{PathWithNamespace: "org/repo1", DefaultBranch: "main"}, "feature/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if branch.Name != "feature/a" || branch.Default || branch.CommitID != "sha-a" {
+		t.Errorf("branch is %+v", branch)
+	}
+}
@@ -0,0 +1,422 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/Logunov/heydevops/helpers"
+	"github.com/xanzy/go-gitlab"
+)
+
+type gitLabProvider struct {
+	client  *gitlab.Client
+	perPage int
+	token   string
+	groups  []string
+	filters Filters
+	retry   *helpers.RetryConfig
+	// concurrency is a number of pages listed at once
+	concurrency int
+}
+
+func newGitLab(config Config) (Provider, error) {
+	// Retries of go-gitlab are replaced with ours, so they are logged and reported
+	client, err := gitlab.NewClient(config.Token,
+		gitlab.WithBaseURL(config.APIURL),
+		gitlab.WithHTTPClient(config.HTTPClient),
+		gitlab.WithoutRetries())
+	if err != nil {
+		return nil, err
+	}
+	return &gitLabProvider{
+		client:      client,
+		perPage:     config.PerPage,
+		token:       config.Token,
+		groups:      config.Groups,
+		filters:     config.Filters,
+		retry:       config.Retry,
+		concurrency: config.Concurrency,
+	}, nil
+}
+
+func (p *gitLabProvider) ListProjects(projects chan<- *Project) error {
+	if len(p.groups) == 0 {
+		return p.listAllProjects(projects)
+	}
+
+	// Groups may overlap when both group and its subgroup are configured
+	seen := make(map[int]bool)
+	for _, group := range p.groups {
+		if err := p.listGroupProjects(group, projects, seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *gitLabProvider) listAllProjects(projects chan<- *Project) error {
+	return p.listPages("list projects", true, func(page int, keyset bool, options ...gitlab.RequestOptionFunc) (*gitlab.Response, error) {
+		listProjectsOptions := &gitlab.ListProjectsOptions{
+			ListOptions:       p.projectListOptions(page, keyset),
+			Owned:             p.filters.Owned,
+			Membership:        p.filters.Membership,
+			Starred:           p.filters.Starred,
+			Archived:          p.filters.Archived,
+			Visibility:        p.visibility(),
+			LastActivityAfter: p.filters.LastActivityAfter,
+		}
+
+		gitLabProjects, response, err := p.client.Projects.ListProjects(listProjectsOptions, options...)
+		if err != nil {
+			return nil, err
+		}
+		for _, gitLabProject := range gitLabProjects {
+			projects <- newGitLabProject(gitLabProject)
+		}
+		return response, nil
+	})
+}
+
+func (p *gitLabProvider) listGroupProjects(group string, projects chan<- *Project, seen map[int]bool) error {
+	var seenMutex sync.Mutex
+	// firstSeen reports whether project wasn't listed before, pages are listed concurrently
+	firstSeen := func(id int) bool {
+		seenMutex.Lock()
+		defer seenMutex.Unlock()
+		if seen[id] {
+			return false
+		}
+		seen[id] = true
+		return true
+	}
+
+	err := p.listPages("list group projects "+group, true, func(page int, keyset bool, options ...gitlab.RequestOptionFunc) (*gitlab.Response, error) {
+		listGroupProjectsOptions := &gitlab.ListGroupProjectsOptions{
+			ListOptions:      p.projectListOptions(page, keyset),
+			IncludeSubGroups: gitlab.Ptr(true),
+			Owned:            p.filters.Owned,
+			Starred:          p.filters.Starred,
+			Archived:         p.filters.Archived,
+			Visibility:       p.visibility(),
+		}
+		// Group projects API has no membership filter, any access level means membership
+		if p.filters.Membership != nil && *p.filters.Membership {
+			listGroupProjectsOptions.MinAccessLevel = gitlab.AccessLevel(gitlab.GuestPermissions)
+		}
+
+		gitLabProjects, response, err := p.client.Groups.ListGroupProjects(group, listGroupProjectsOptions, options...)
+		if err != nil {
+			return nil, err
+		}
+		for _, gitLabProject := range gitLabProjects {
+			if !firstSeen(gitLabProject.ID) {
+				continue
+			}
+			// Group projects API has no last activity filter
+			if project := newGitLabProject(gitLabProject); p.filters.match(project) {
+				projects <- project
+			}
+		}
+		return response, nil
+	})
+	if err != nil {
+		return fmt.Errorf("group %s: %w", group, err)
+	}
+	return nil
+}
+
+// listPageFunc lists page of offset paginated result set or keyset paginated one,
+// options carry keyset pagination parameters of the next page
+type listPageFunc func(page int, keyset bool, options ...gitlab.RequestOptionFunc) (*gitlab.Response, error)
+
+// listPages lists all pages with keyset pagination when it is requested and the server supports it,
+// otherwise pages of offset pagination are listed concurrently when their total number is known
+func (p *gitLabProvider) listPages(what string, keyset bool, listPage listPageFunc) error {
+	fetch := func(page int, keyset bool, options ...gitlab.RequestOptionFunc) (*gitlab.Response, error) {
+		var response *gitlab.Response
+		err := p.retry.Do(what, retryable, func() (err error) {
+			response, err = listPage(page, keyset, options...)
+			return err
+		})
+		return response, err
+	}
+
+	response, err := fetch(1, keyset)
+	if keyset && keysetUnsupported(err) {
+		response, err = fetch(1, false)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case response.CurrentPage == 0:
+		// Keyset paginated response has neither page number nor totals, only the next page link
+		for response.NextLink != "" {
+			if response, err = fetch(0, true, gitlab.WithKeysetPaginationParameters(response.NextLink)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case response.TotalPages > response.CurrentPage:
+		return p.listPagesConcurrently(response.CurrentPage+1, response.TotalPages, fetch)
+	default:
+		// GitLab doesn't count totals of large result sets, such pages are listed one by one
+		for response.NextPage != 0 {
+			if response, err = fetch(response.NextPage, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func (p *gitLabProvider) listPagesConcurrently(first int, last int, fetch listPageFunc) error {
+	pages := make(chan int)
+	var firstErr error
+	var errMutex sync.Mutex
+	failed := func(err error) bool {
+		errMutex.Lock()
+		defer errMutex.Unlock()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		return firstErr != nil
+	}
+
+	var waitGroup sync.WaitGroup
+	for i := 0; i < p.concurrency && i <= last-first; i++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			for page := range pages {
+				_, err := fetch(page, false)
+				failed(err)
+			}
+		}()
+	}
+
+	for page := first; page <= last && !failed(nil); page++ {
+		pages <- page
+	}
+	close(pages)
+	waitGroup.Wait()
+	return firstErr
+}
+
+func (p *gitLabProvider) listOptions(page int, keyset bool) gitlab.ListOptions {
+	if keyset {
+		return gitlab.ListOptions{Pagination: "keyset", OrderBy: "id", Sort: "asc", PerPage: p.perPage}
+	}
+	return gitlab.ListOptions{PerPage: p.perPage, Page: page}
+}
+
+// projectListOptions orders projects by id with offset pagination too, servers ignoring keyset pagination
+// answer the first page in that order and the next pages must be listed in the same one
+func (p *gitLabProvider) projectListOptions(page int, keyset bool) gitlab.ListOptions {
+	listOptions := p.listOptions(page, keyset)
+	listOptions.OrderBy, listOptions.Sort = "id", "asc"
+	return listOptions
+}
+
+// keysetUnsupported reports whether the server rejected keyset pagination of the request
+func keysetUnsupported(err error) bool {
+	var gitLabError *gitlab.ErrorResponse
+	if !errors.As(err, &gitLabError) || gitLabError.Response == nil {
+		return false
+	}
+	statusCode := gitLabError.Response.StatusCode
+	return statusCode == http.StatusBadRequest || statusCode == http.StatusMethodNotAllowed
+}
+
+func (p *gitLabProvider) visibility() *gitlab.VisibilityValue {
+	if p.filters.Visibility == "" {
+		return nil
+	}
+	return gitlab.Visibility(gitlab.VisibilityValue(p.filters.Visibility))
+}
+
+func (p *gitLabProvider) ListBranches(project *Project) ([]*Branch, error) {
+	var branches []*Branch
+
+	listBranchesOptions := &gitlab.ListBranchesOptions{
+		ListOptions: gitlab.ListOptions{
+			PerPage: p.perPage,
+			Page:    1,
+		},
+	}
+
+	for {
+		// Get the first page with branches.
+		var gitLabBranches []*gitlab.Branch
+		var response *gitlab.Response
+		err := p.retry.Do("list branches "+project.PathWithNamespace, retryable, func() (err error) {
+			gitLabBranches, response, err = p.client.Branches.ListBranches(project.ID, listBranchesOptions)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// List all the branches we've found so far.
+		for _, gitLabBranch := range gitLabBranches {
+			branches = append(branches, newGitLabBranch(gitLabBranch))
+		}
+
+		// Exit the loop when we've seen all pages.
+		if response.CurrentPage >= response.TotalPages {
+			break
+		}
+
+		// Update the page number to get the next page.
+		listBranchesOptions.Page = response.NextPage
+	}
+	return branches, nil
+}
+
+func (p *gitLabProvider) GetBranch(project *Project, name string) (*Branch, error) {
+	var gitLabBranch *gitlab.Branch
+	err := p.retry.Do("get branch "+name+" of "+project.PathWithNamespace, retryable, func() (err error) {
+		gitLabBranch, _, err = p.client.Branches.GetBranch(project.ID, name)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newGitLabBranch(gitLabBranch), nil
+}
+
+func (p *gitLabProvider) ListTags(project *Project) ([]*Tag, error) {
+	var tags []*Tag
+	var tagsMutex sync.Mutex
+
+	err := p.listPages("list tags "+project.PathWithNamespace, false, func(page int, keyset bool, options ...gitlab.RequestOptionFunc) (*gitlab.Response, error) {
+		listTagsOptions := &gitlab.ListTagsOptions{
+			ListOptions: p.listOptions(page, keyset),
+		}
+
+		gitLabTags, response, err := p.client.Tags.ListTags(project.ID, listTagsOptions, options...)
+		if err != nil {
+			return nil, err
+		}
+		tagsMutex.Lock()
+		defer tagsMutex.Unlock()
+		for _, gitLabTag := range gitLabTags {
+			tag := &Tag{Name: gitLabTag.Name}
+			if gitLabTag.Commit != nil {
+				tag.CommitID = gitLabTag.Commit.ID
+			}
+			tags = append(tags, tag)
+		}
+		return response, nil
+	})
+	return tags, err
+}
+
+func (p *gitLabProvider) ListMergeRequests(project *Project) ([]*MergeRequest, error) {
+	var mergeRequests []*MergeRequest
+	var mergeRequestsMutex sync.Mutex
+
+	err := p.listPages("list merge requests "+project.PathWithNamespace, false, func(page int, keyset bool, options ...gitlab.RequestOptionFunc) (*gitlab.Response, error) {
+		listMergeRequestsOptions := &gitlab.ListProjectMergeRequestsOptions{
+			ListOptions: p.listOptions(page, keyset),
+			State:       gitlab.Ptr("opened"),
+		}
+
+		gitLabMergeRequests, response, err := p.client.MergeRequests.ListProjectMergeRequests(project.ID, listMergeRequestsOptions, options...)
+		if err != nil {
+			return nil, err
+		}
+		mergeRequestsMutex.Lock()
+		defer mergeRequestsMutex.Unlock()
+		for _, gitLabMergeRequest := range gitLabMergeRequests {
+			mergeRequests = append(mergeRequests, newGitLabMergeRequest(gitLabMergeRequest))
+		}
+		return response, nil
+	})
+	return mergeRequests, err
+}
+
+func (p *gitLabProvider) CloneURL(project *Project, protocol string) (string, error) {
+	return cloneURL(project, protocol)
+}
+
+func (p *gitLabProvider) Credentials() (string, string) {
+	return "oauth2", p.token
+}
+
+func newGitLabProject(gitLabProject *gitlab.Project) *Project {
+	project := &Project{
+		ID:                strconv.Itoa(gitLabProject.ID),
+		Name:              gitLabProject.Path,
+		PathWithNamespace: gitLabProject.PathWithNamespace,
+		WebURL:            gitLabProject.WebURL,
+		SSHURL:            gitLabProject.SSHURLToRepo,
+		HTTPURL:           gitLabProject.HTTPURLToRepo,
+		DefaultBranch:     gitLabProject.DefaultBranch,
+		Archived:          gitLabProject.Archived,
+		WikiEnabled:       gitLabProject.WikiEnabled,
+		Visibility:        string(gitLabProject.Visibility),
+		LastActivityAt:    gitLabProject.LastActivityAt,
+		Topics:            gitLabProject.Topics,
+		EmptyRepo:         gitLabProject.EmptyRepo,
+		Fork:              gitLabProject.ForkedFromProject != nil,
+	}
+	if gitLabProject.Namespace != nil {
+		project.Namespace = gitLabProject.Namespace.FullPath
+		project.NamespaceKind = gitLabProject.Namespace.Kind
+	}
+	return project
+}
+
+func newGitLabBranch(gitLabBranch *gitlab.Branch) *Branch {
+	branch := &Branch{
+		Name:      gitLabBranch.Name,
+		Default:   gitLabBranch.Default,
+		Protected: gitLabBranch.Protected,
+	}
+	if gitLabBranch.Commit != nil {
+		branch.CommitID = gitLabBranch.Commit.ID
+		branch.CommittedAt = gitLabBranch.Commit.CommittedDate
+	}
+	return branch
+}
+
+func newGitLabMergeRequest(gitLabMergeRequest *gitlab.MergeRequest) *MergeRequest {
+	mergeRequest := &MergeRequest{
+		IID:          gitLabMergeRequest.IID,
+		Title:        gitLabMergeRequest.Title,
+		WebURL:       gitLabMergeRequest.WebURL,
+		SourceBranch: gitLabMergeRequest.SourceBranch,
+		TargetBranch: gitLabMergeRequest.TargetBranch,
+		Labels:       gitLabMergeRequest.Labels,
+		Draft:        gitLabMergeRequest.Draft,
+		Fork:         gitLabMergeRequest.SourceProjectID != gitLabMergeRequest.TargetProjectID,
+		Ref:          fmt.Sprintf("refs/merge-requests/%d/head", gitLabMergeRequest.IID),
+		CommitID:     gitLabMergeRequest.SHA,
+	}
+	if gitLabMergeRequest.Author != nil {
+		mergeRequest.Author = gitLabMergeRequest.Author.Username
+	}
+	return mergeRequest
+}
@@ -0,0 +1,309 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package provider
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// gitLabProjectsJSON returns projects with ids from 1 to count
+func gitLabProjectsJSON(count int) []map[string]interface{} {
+	var projects []map[string]interface{}
+	for id := 1; id <= count; id++ {
+		name := "project" + strconv.Itoa(id)
+		projects = append(projects, map[string]interface{}{
+			"id":                  id,
+			"path":                name,
+			"path_with_namespace": "group/" + name,
+			"web_url":             "https://gitlab.example.com/group/" + name,
+			"namespace":           map[string]interface{}{"full_path": "group", "kind": "group"},
+		})
+	}
+	return projects
+}
+
+// offsetPage writes page of projects with offset pagination headers
+func offsetPage(writer http.ResponseWriter, request *http.Request, projects []map[string]interface{}) {
+	query := request.URL.Query()
+	perPage, _ := strconv.Atoi(query.Get("per_page"))
+	page, _ := strconv.Atoi(query.Get("page"))
+	if page == 0 {
+		page = 1
+	}
+	totalPages := (len(projects) + perPage - 1) / perPage
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("X-Page", strconv.Itoa(page))
+	writer.Header().Set("X-Per-Page", strconv.Itoa(perPage))
+	writer.Header().Set("X-Total", strconv.Itoa(len(projects)))
+	writer.Header().Set("X-Total-Pages", strconv.Itoa(totalPages))
+	if page < totalPages {
+		writer.Header().Set("X-Next-Page", strconv.Itoa(page+1))
+	}
+
+	start, end := (page-1)*perPage, page*perPage
+	if end > len(projects) {
+		end = len(projects)
+	}
+	_ = json.NewEncoder(writer).Encode(projects[start:end])
+}
+
+func newTestGitLab(t *testing.T, handler http.HandlerFunc, groups []string) Provider {
+	t.Helper()
+	return newTestProvider(t, handler, Config{Kind: GitLab, Groups: groups})
+}
+
+// newTestProvider creates provider of config kind talking to handler, pages have 3 items
+func newTestProvider(t *testing.T, handler http.HandlerFunc, config Config) Provider {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	// GitHub and Gitea derive API address from the same one, a distinct path keeps it
+	config.URL, config.APIURL, config.Token = server.URL+"/", server.URL+"/", "token"
+	if config.Kind != "" && config.Kind != GitLab {
+		config.APIURL = server.URL + "/api/"
+	}
+	config.PerPage, config.Concurrency = 3, 3
+	hosting, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hosting
+}
+
+// listProjects returns projects listed by hosting
+func listProjects(hosting Provider) ([]*Project, error) {
+	projects := make(chan *Project)
+	var listed []*Project
+	done := make(chan struct{})
+	go func() {
+		for project := range projects {
+			listed = append(listed, project)
+		}
+		close(done)
+	}()
+	err := hosting.ListProjects(projects)
+	close(projects)
+	<-done
+	return listed, err
+}
+
+// listProjectIDs returns sorted ids of projects listed by hosting
+func listProjectIDs(t *testing.T, hosting Provider) []int {
+	t.Helper()
+	projects, err := listProjects(hosting)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int
+	for _, project := range projects {
+		id, _ := strconv.Atoi(project.ID)
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func checkIDs(t *testing.T, what string, ids []int, count int) {
+	t.Helper()
+	if len(ids) != count {
+		t.Errorf("%s: listed %v, want 1 to %d", what, ids, count)
+		return
+	}
+	for i, id := range ids {
+		if id != i+1 {
+			t.Errorf("%s: listed %v, want 1 to %d", what, ids, count)
+			return
+		}
+	}
+}
+
+// TestGitLabKeysetIgnored checks that pages of server ignoring keyset pagination are listed in one order,
+// the server sorts by id only when asked and by creation time descending otherwise
+func TestGitLabKeysetIgnored(t *testing.T) {
+	for _, groups := range [][]string{nil, {"group"}} {
+		hosting := newTestGitLab(t, func(writer http.ResponseWriter, request *http.Request) {
+			projects := gitLabProjectsJSON(10)
+			query := request.URL.Query()
+			if query.Get("order_by") != "id" || query.Get("sort") != "asc" {
+				sort.Slice(projects, func(i, j int) bool {
+					return projects[i]["id"].(int) > projects[j]["id"].(int)
+				})
+			}
+			offsetPage(writer, request, projects)
+		}, groups)
+
+		checkIDs(t, fmt.Sprintf("groups %v", groups), listProjectIDs(t, hosting), 10)
+	}
+}
+
+// TestGitLabKeyset checks that keyset paginated projects are listed following next links with filters
+func TestGitLabKeyset(t *testing.T) {
+	hosting := newTestProvider(t, func(writer http.ResponseWriter, request *http.Request) {
+		query := request.URL.Query()
+		if request.URL.Path != "/api/v4/projects" || query.Get("pagination") != "keyset" || query.Get("order_by") != "id" {
+			t.Errorf("unexpected request %s", request.URL)
+		}
+		if query.Get("archived") != "false" || query.Get("visibility") != "private" {
+			t.Errorf("filters aren't sent: %s", request.URL)
+		}
+		if request.Header.Get("PRIVATE-TOKEN") != "token" {
+			t.Errorf("token isn't sent")
+		}
+
+		after, _ := strconv.Atoi(query.Get("id_after"))
+		projects := gitLabProjectsJSON(8)[after:]
+		if len(projects) > 3 {
+			projects = projects[:3]
+			next := fmt.Sprintf("http://%s/api/v4/projects?pagination=keyset&per_page=3&order_by=id&sort=asc&archived=false&visibility=private&id_after=%d", request.Host, after+3)
+			writer.Header().Set("Link", "<"+next+`>; rel="next"`)
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(writer).Encode(projects)
+	}, Config{Kind: GitLab, Filters: Filters{Archived: boolPtr(false), Visibility: "private"}})
+
+	checkIDs(t, "keyset", listProjectIDs(t, hosting), 8)
+}
+
+// TestGitLabKeysetRejected checks fallback to offset pagination pages listed concurrently
+func TestGitLabKeysetRejected(t *testing.T) {
+	var requests int32
+	hosting := newTestProvider(t, func(writer http.ResponseWriter, request *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if request.URL.Query().Get("pagination") == "keyset" {
+			http.Error(writer, `{"message":"keyset pagination isn't supported"}`, http.StatusBadRequest)
+			return
+		}
+		offsetPage(writer, request, gitLabProjectsJSON(10))
+	}, Config{Kind: GitLab})
+
+	checkIDs(t, "offset", listProjectIDs(t, hosting), 10)
+	// Rejected keyset page and 4 offset pages
+	if requests != 5 {
+		t.Errorf("%d requests, want 5", requests)
+	}
+}
+
+// TestGitLabGroups checks that projects of overlapping groups are listed once and filters group API lacks are applied
+func TestGitLabGroups(t *testing.T) {
+	activity := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var subgroupRequests int32
+	hosting := newTestProvider(t, func(writer http.ResponseWriter, request *http.Request) {
+		query := request.URL.Query()
+		if query.Get("include_subgroups") != "true" || query.Get("min_access_level") != "10" {
+			t.Errorf("group options aren't sent: %s", request.URL)
+		}
+		projects := gitLabProjectsJSON(5)
+		for i, project := range projects {
+			project["last_activity_at"] = activity.AddDate(0, 0, i)
+		}
+		if strings.Contains(request.URL.EscapedPath(), "/groups/group%2Fsub/") {
+			atomic.AddInt32(&subgroupRequests, 1)
+			projects = projects[3:]
+		}
+		offsetPage(writer, request, projects)
+	}, Config{Kind: GitLab, Groups: []string{"group", "group/sub"}, Filters: Filters{
+		Membership:        boolPtr(true),
+		LastActivityAfter: gitlab.Ptr(activity.AddDate(0, 0, 1)),
+	}})
+
+	ids := listProjectIDs(t, hosting)
+	if fmt.Sprint(ids) != "[3 4 5]" || subgroupRequests == 0 {
+		t.Errorf("listed %v, want [3 4 5] of both groups", ids)
+	}
+}
+
+func TestGitLabBranches(t *testing.T) {
+	hosting := newTestProvider(t, func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path != "/api/v4/projects/1/repository/branches" {
+			t.Errorf("unexpected request %s", request.URL)
+		}
+		var branches []map[string]interface{}
+		for _, name := range []string{"develop", "feature/a", "main", "release"} {
+			branches = append(branches, map[string]interface{}{
+				"name":      name,
+				"default":   name == "main",
+				"protected": name == "main",
+				"commit":    map[string]interface{}{"id": "sha-" + name, "committed_date": "2024-01-01T00:00:00Z"},
+			})
+		}
+		offsetPage(writer, request, branches)
+	}, Config{Kind: GitLab})
+
+	branches, err := hosting.ListBranches(&Project{ID: "1", PathWithNamespace: "group/project1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(branches) != 4 {
+		t.Fatalf("listed %d branches, want 4", len(branches))
+	}
+	main := branches[2]
+	if main.Name != "main" || !main.Default || !main.Protected || main.CommitID != "sha-main" || main.CommittedAt == nil {
+		t.Errorf("main branch is %+v", main)
+	}
+}
+
+func TestGitLabError(t *testing.T) {
+	hosting := newTestProvider(t, func(writer http.ResponseWriter, request *http.Request) {
+		http.Error(writer, `{"message":"403 Forbidden"}`, http.StatusForbidden)
+	}, Config{Kind: GitLab})
+
+	_, err := listProjects(hosting)
+	var gitLabError *gitlab.ErrorResponse
+	if !errors.As(err, &gitLabError) || gitLabError.Response.StatusCode != http.StatusForbidden {
+		t.Errorf("error is %v, want 403 response", err)
+	}
+}
+
+func boolPtr(value bool) *bool {
+	return &value
+}
+
+func TestGitLabGetBranch(t *testing.T) {
+	hosting := newTestProvider(t, func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.EscapedPath() != "/api/v4/projects/1/repository/branches/feature%2Fa" {
+			t.Errorf("unexpected request %s", request.URL)
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(writer).Encode(map[string]interface{}{
+			"name":   "feature/a",
+			"commit": map[string]interface{}{"id": "sha-a", "committed_date": "2024-01-01T00:00:00Z"},
+		})
+	}, Config{Kind: GitLab})
+
+	branch, err := hosting.GetBranch(&Project{ID: "1", PathWithNamespace: "group/project1"}, "feature/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if branch.Name != "feature/a" || branch.CommitID != "sha-a" || branch.CommittedAt == nil {
+		t.Errorf("branch is %+v", branch)
+	}
+}
@@ -0,0 +1,188 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package provider
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Logunov/heydevops/helpers"
+)
+
+const (
+	GitLab = "gitlab"
+	GitHub = "github"
+	Gitea  = "gitea"
+
+	NamespaceUser  = "user"
+	NamespaceGroup = "group"
+
+	ProtocolSSH   = "ssh"
+	ProtocolHTTPS = "https"
+)
+
+// Provider is a source code hosting which repositories can be cloned from
+type Provider interface {
+	// ListProjects sends every project of the configured groups (or visible with the token when
+	// there are no groups) that passes the configured filters to projects
+	ListProjects(projects chan<- *Project) error
+	// ListBranches returns all branches of the project
+	ListBranches(project *Project) ([]*Branch, error)
+	// GetBranch returns the branch of the project by its name
+	GetBranch(project *Project, name string) (*Branch, error)
+	// ListTags returns all tags of the project
+	ListTags(project *Project) ([]*Tag, error)
+	// ListMergeRequests returns open merge (pull) requests targeting the project
+	ListMergeRequests(project *Project) ([]*MergeRequest, error)
+	// CloneURL returns URL the project should be cloned from using protocol
+	CloneURL(project *Project, protocol string) (string, error)
+	// Credentials returns username and password for cloning over HTTPS with the token
+	Credentials() (string, string)
+}
+
+type Config struct {
+	Kind       string
+	URL        string
+	APIURL     string
+	Token      string
+	PerPage    int
+	HTTPClient *http.Client
+	Groups     []string
+	Filters    Filters
+	// Retry sets retries of failed API calls up, nil means no retries
+	Retry *helpers.RetryConfig
+	// Concurrency is a number of pages listed at once when the API supports it
+	Concurrency int
+}
+
+// Filters narrow down listed projects, nil or empty value means no filtering
+type Filters struct {
+	Owned             *bool
+	Membership        *bool
+	Starred           *bool
+	Archived          *bool
+	Visibility        string
+	LastActivityAfter *time.Time
+}
+
+type Project struct {
+	ID                string
+	Name              string
+	Namespace         string
+	PathWithNamespace string
+	WebURL            string
+	SSHURL            string
+	HTTPURL           string
+	DefaultBranch     string
+	Archived          bool
+	Visibility        string
+	LastActivityAt    *time.Time
+	WikiEnabled       bool
+	Topics            []string
+	EmptyRepo         bool
+	Fork              bool
+	// NamespaceKind is user or group, empty when the provider doesn't tell
+	NamespaceKind string
+}
+
+type Branch struct {
+	Name      string
+	Default   bool
+	Protected bool
+	CommitID  string
+	// CommittedAt is nil when the provider doesn't return it with branches
+	CommittedAt *time.Time
+}
+
+type Tag struct {
+	Name     string
+	CommitID string
+}
+
+// MergeRequest is an open merge request of GitLab or pull request of GitHub and Gitea
+type MergeRequest struct {
+	IID          int
+	Title        string
+	WebURL       string
+	SourceBranch string
+	TargetBranch string
+	Author       string
+	Labels       []string
+	Draft        bool
+	// Fork is true when source branch belongs to another project
+	Fork bool
+	// Ref is a ref of the source branch head in the target project, it is there for forks too
+	Ref      string
+	CommitID string
+}
+
+// New creates provider of config.Kind, GitLab is used when kind is empty
+func New(config Config) (Provider, error) {
+	if config.HTTPClient == nil {
+		config.HTTPClient = http.DefaultClient
+	}
+	if config.PerPage <= 0 {
+		config.PerPage = 20
+	}
+	if config.Concurrency <= 0 {
+		config.Concurrency = 1
+	}
+
+	config.Kind = strings.ToLower(config.Kind)
+	switch config.Kind {
+	case "", GitLab:
+		return newGitLab(config)
+	case GitHub, Gitea:
+		if config.Filters.Owned != nil || config.Filters.Membership != nil || config.Filters.Starred != nil {
+			return nil, fmt.Errorf("owned, membership and starred filters are supported by %s provider only", GitLab)
+		}
+		if config.Kind == GitHub {
+			return newGitHub(config)
+		}
+		return newGitea(config)
+	default:
+		return nil, fmt.Errorf("unknown provider %q, supported: %s, %s, %s", config.Kind, GitLab, GitHub, Gitea)
+	}
+}
+
+// match applies filters for providers which API can't filter projects itself
+func (f *Filters) match(project *Project) bool {
+	if f.Archived != nil && *f.Archived != project.Archived {
+		return false
+	}
+	if f.Visibility != "" && !strings.EqualFold(f.Visibility, project.Visibility) {
+		return false
+	}
+	if f.LastActivityAfter != nil && project.LastActivityAt != nil && !project.LastActivityAt.After(*f.LastActivityAfter) {
+		return false
+	}
+	return true
+}
+
+func cloneURL(project *Project, protocol string) (string, error) {
+	switch strings.ToLower(protocol) {
+	case "", ProtocolSSH:
+		return project.SSHURL, nil
+	case ProtocolHTTPS:
+		return project.HTTPURL, nil
+	default:
+		return "", fmt.Errorf("unknown clone protocol %q, supported: %s, %s", protocol, ProtocolSSH, ProtocolHTTPS)
+	}
+}
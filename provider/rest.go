@@ -0,0 +1,99 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	re "regexp"
+
+	"github.com/Logunov/heydevops/helpers"
+)
+
+var linkNextRegexp = re.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// ResponseError is returned when hosting API responds with non 2xx status
+type ResponseError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Header     http.Header
+	Body       string
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("%s %s: %d %s", e.Method, e.URL, e.StatusCode, e.Body)
+}
+
+// restClient is a minimal JSON REST client for hostings without dedicated SDK
+type restClient struct {
+	httpClient    *http.Client
+	authorization string
+	retry         *helpers.RetryConfig
+}
+
+// getJSON decodes response of GET rawURL into out and returns next page URL
+// from Link header, which is empty on the last page, transient failures are retried
+func (c *restClient) getJSON(rawURL string, out interface{}) (string, error) {
+	var next string
+	err := c.retry.Do("GET "+rawURL, retryable, func() error {
+		var err error
+		next, err = c.fetchJSON(rawURL, out)
+		return err
+	})
+	return next, err
+}
+
+func (c *restClient) fetchJSON(rawURL string, out interface{}) (string, error) {
+	request, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Accept", "application/json")
+	if c.authorization != "" {
+		request.Header.Set("Authorization", c.authorization)
+	}
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return "", &ResponseError{
+			Method:     request.Method,
+			URL:        rawURL,
+			StatusCode: response.StatusCode,
+			Header:     response.Header,
+			Body:       string(body),
+		}
+	}
+
+	if err := json.NewDecoder(response.Body).Decode(out); err != nil {
+		return "", fmt.Errorf("GET %s: can't decode response: %w", rawURL, err)
+	}
+
+	if match := linkNextRegexp.FindStringSubmatch(response.Header.Get("Link")); match != nil {
+		return match[1], nil
+	}
+	return "", nil
+}
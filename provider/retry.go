@@ -0,0 +1,80 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package provider
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// retryable reports whether API call failed with transient error and how long the server asked to wait
+func retryable(err error) (bool, time.Duration) {
+	var responseError *ResponseError
+	if errors.As(err, &responseError) {
+		return retryableResponse(responseError.StatusCode, responseError.Header)
+	}
+
+	var gitLabError *gitlab.ErrorResponse
+	if errors.As(err, &gitLabError) && gitLabError.Response != nil {
+		return retryableResponse(gitLabError.Response.StatusCode, gitLabError.Response.Header)
+	}
+
+	var netError net.Error
+	if errors.As(err, &netError) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true, 0
+	}
+	return false, 0
+}
+
+func retryableResponse(statusCode int, header http.Header) (bool, time.Duration) {
+	switch {
+	case statusCode == http.StatusTooManyRequests || statusCode >= 500:
+		return true, retryAfter(header)
+	// GitHub answers 403 when rate limit is exhausted
+	case statusCode == http.StatusForbidden && header.Get("X-RateLimit-Remaining") == "0":
+		return true, retryAfter(header)
+	default:
+		return false, 0
+	}
+}
+
+// retryAfter returns delay from Retry-After header (seconds or HTTP date),
+// otherwise time until rate limit reset from RateLimit-Reset (GitLab) or X-RateLimit-Reset (GitHub)
+func retryAfter(header http.Header) time.Duration {
+	if value := header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil {
+			return time.Duration(seconds) * time.Second
+		}
+		if date, err := http.ParseTime(value); err == nil {
+			return time.Until(date)
+		}
+	}
+
+	for _, name := range []string{"RateLimit-Reset", "X-RateLimit-Reset"} {
+		if reset, err := strconv.ParseInt(header.Get(name), 10, 64); err == nil && reset > 0 {
+			return time.Until(time.Unix(reset, 0))
+		}
+	}
+	return 0
+}
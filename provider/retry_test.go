@@ -0,0 +1,77 @@
+/*
+Copyright © 2019 Ilya V. Logounov <ilya@logounov.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package provider
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+	}{
+		{"none", http.Header{}, 0},
+		{"seconds", http.Header{"Retry-After": {"30"}}, 30 * time.Second},
+		{"date", http.Header{"Retry-After": {now.Add(time.Minute).UTC().Format(http.TimeFormat)}}, time.Minute},
+		{"garbage", http.Header{"Retry-After": {"soon"}}, 0},
+		{"gitlab reset", http.Header{"Ratelimit-Reset": {strconv.FormatInt(now.Add(2*time.Minute).Unix(), 10)}}, 2 * time.Minute},
+		{"github reset", http.Header{"X-Ratelimit-Reset": {strconv.FormatInt(now.Add(3*time.Minute).Unix(), 10)}}, 3 * time.Minute},
+		{"retry after wins", http.Header{
+			"Retry-After":       {"5"},
+			"X-Ratelimit-Reset": {strconv.FormatInt(now.Add(time.Hour).Unix(), 10)},
+		}, 5 * time.Second},
+	}
+	for _, test := range tests {
+		got := retryAfter(test.header)
+		// Dates are rounded to seconds
+		if got < test.want-2*time.Second || got > test.want {
+			t.Errorf("%s: retryAfter is %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRetryableResponse(t *testing.T) {
+	tests := []struct {
+		statusCode int
+		remaining  string
+		want       bool
+	}{
+		{http.StatusTooManyRequests, "", true},
+		{http.StatusBadGateway, "", true},
+		{http.StatusForbidden, "0", true},
+		{http.StatusForbidden, "10", false},
+		{http.StatusForbidden, "", false},
+		{http.StatusNotFound, "", false},
+		{http.StatusUnauthorized, "", false},
+	}
+	for _, test := range tests {
+		header := http.Header{}
+		if test.remaining != "" {
+			header.Set("X-RateLimit-Remaining", test.remaining)
+		}
+		if got, _ := retryableResponse(test.statusCode, header); got != test.want {
+			t.Errorf("retryableResponse(%d, remaining %q) = %v, want %v", test.statusCode, test.remaining, got, test.want)
+		}
+	}
+}